@@ -0,0 +1,141 @@
+package customer
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Scopes of api keys, a key only has access to the routes of its scopes while sessions have
+// every scope
+const (
+	ScopeEndpointsWrite   = "endpoints:write"
+	ScopeEventsRead       = "events:read"
+	ScopeEventsReplay     = "events:replay"
+	ScopeDeliveriesRead   = "deliveries:read"
+	ScopeAPIKeysRead      = "api_keys:read"
+	ScopeAPIKeysWrite     = "api_keys:write"
+	ScopeSessionsRead     = "sessions:read"
+	ScopeSessionsWrite    = "sessions:write"
+	ScopeProfileRead      = "profile:read"
+	ScopeProfileWrite     = "profile:write"
+	ScopeSubAccountsRead  = "sub_accounts:read"
+	ScopeSubAccountsWrite = "sub_accounts:write"
+)
+
+// AllScopes lists every scope
+var AllScopes = Scopes{
+	ScopeEndpointsWrite,
+	ScopeEventsRead,
+	ScopeEventsReplay,
+	ScopeDeliveriesRead,
+	ScopeAPIKeysRead,
+	ScopeAPIKeysWrite,
+	ScopeSessionsRead,
+	ScopeSessionsWrite,
+	ScopeProfileRead,
+	ScopeProfileWrite,
+	ScopeMembersRead,
+	ScopeMembersWrite,
+	ScopeSubAccountsRead,
+	ScopeSubAccountsWrite,
+}
+
+// APIKeyPrefix starts every api key so leaked keys are easy to recognize
+const APIKeyPrefix = "nsk_"
+
+// APIKey lets customer's services authenticate without a session, only the hash of the key
+// is stored
+type APIKey struct {
+	ID         uint64 `json:"id" gorm:"primary_key"`
+	CustomerID uint64 `json:"-" gorm:"index"`
+	// CreatedBy is the member who created the key for the organization, the key is revoked
+	// when the member leaves or is removed
+	CreatedBy  *uint64    `json:"created_by" gorm:"index"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-" gorm:"uniqueIndex"`
+	Scopes     Scopes     `json:"scopes" gorm:"type:jsonb"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// NewAPIKey returns new api key of the customer with the plain key, the plain key can't be
+// recovered from the api key
+func NewAPIKey(customerID uint64, name string, scopes []string) (*APIKey, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	key := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	return &APIKey{
+		CustomerID: customerID,
+		Name:       name,
+		Prefix:     key[:len(APIKeyPrefix)+6],
+		Hash:       HashAPIKey(key),
+		Scopes:     scopes,
+	}, key, nil
+}
+
+// HashAPIKey returns hash of the plain key used to look the key up, keys are random so a
+// fast hash is enough
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Scopes is a list of permissions
+type Scopes []string
+
+// Has reports whether scope is one of the scopes
+func (s Scopes) Has(scope string) bool {
+	for _, granted := range s {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAll reports whether every one of the other scopes is one of the scopes
+func (s Scopes) HasAll(other Scopes) bool {
+	for _, scope := range other {
+		if !s.Has(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// Value returns json encoded scopes to be stored in database
+func (s Scopes) Value() (driver.Value, error) {
+	if s == nil {
+		return "[]", nil
+	}
+	bytes, err := json.Marshal(s)
+	return string(bytes), err
+}
+
+// Scan decodes json encoded scopes from database
+func (s *Scopes) Scan(value interface{}) error {
+	var bytes []byte
+	switch v := value.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return fmt.Errorf("can't scan %T into scopes", value)
+	}
+	return json.Unmarshal(bytes, s)
+}
@@ -1,10 +1,27 @@
 package customer
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Notification channels supported for customer's callback
+const (
+	ChannelHTTP  = "http"
+	ChannelEmail = "email"
+	ChannelSSE   = "sse"
+	ChannelAMQP  = "amqp"
+	ChannelNATS  = "nats"
+)
+
 // Callback stores customer's notification callback settings
 type Callback struct {
 	BaseModel
-	CustomerID  uint   `json:"-" gorm:"index"`
-	CallbackURL string `json:"callback_url"`
+	CustomerID  uint          `json:"-" gorm:"index"`
+	CallbackURL string        `json:"callback_url"`
+	Channel     string        `json:"channel" gorm:"default:http"`
+	Config      ChannelConfig `json:"config" gorm:"type:jsonb"`
 }
 
 // NewCallback returns new customer's callback settings
@@ -12,5 +29,35 @@ func NewCallback(callbackURL string, customerID uint) *Callback {
 	return &Callback{
 		CustomerID:  customerID,
 		CallbackURL: callbackURL,
+		Channel:     ChannelHTTP,
+	}
+}
+
+// ChannelConfig stores channel specific settings of a callback, e.g. email recipient
+type ChannelConfig map[string]string
+
+// Value returns json encoded config to be stored in database
+func (c ChannelConfig) Value() (driver.Value, error) {
+	if c == nil {
+		return nil, nil
+	}
+	bytes, err := json.Marshal(c)
+	return string(bytes), err
+}
+
+// Scan decodes json encoded config from database
+func (c *ChannelConfig) Scan(value interface{}) error {
+	var bytes []byte
+	switch v := value.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return fmt.Errorf("can't scan %T into channel config", value)
 	}
+	return json.Unmarshal(bytes, c)
 }
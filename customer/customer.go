@@ -1,17 +1,62 @@
 package customer
 
+import (
+	"time"
+)
+
 // Customer stores customer's information
 type Customer struct {
 	BaseModel
-	Email    string    `json:"email" gorm:"uniqueIndex"`
-	Password string    `json:"-"`
-	Callback *Callback `json:"-"`
+	Email           string     `json:"email" gorm:"uniqueIndex"`
+	Password        string     `json:"-"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Callback        *Callback  `json:"callback"`
+
+	// Profile of the customer, Timezone is an IANA time zone name
+	CompanyName  string `json:"company_name"`
+	ContactPhone string `json:"contact_phone"`
+	Timezone     string `json:"timezone"`
+
+	// ParentID is set for sub-accounts, they are managed by the parent customer which also
+	// gets their events when ForwardToParent is set
+	ParentID        *uint64 `json:"parent_id" gorm:"index"`
+	ForwardToParent bool    `json:"forward_to_parent"`
+
+	// TOTPSecret is set on 2fa enrolment, 2fa is only enabled once TwoFactorEnabledAt is set
+	TOTPSecret         string        `json:"-"`
+	TOTPLastStep       int64         `json:"-"`
+	TwoFactorEnabledAt *time.Time    `json:"two_factor_enabled_at"`
+	RecoveryCodes      RecoveryCodes `json:"-" gorm:"type:jsonb"`
 }
 
-// New returns new customer
+// New returns new customer, its email is unverified
 func New(name, hashedPassword string) *Customer {
 	return &Customer{
 		Email:    name,
 		Password: hashedPassword,
 	}
 }
+
+// NewSubAccount returns new sub-account of the parent without password, its email isn't
+// verified until its owner verifies it like on register
+func NewSubAccount(parent *Customer, email string) *Customer {
+	return &Customer{
+		Email:    email,
+		ParentID: &parent.ID,
+	}
+}
+
+// IsSubAccount reports whether the customer is managed by a parent customer
+func (c *Customer) IsSubAccount() bool {
+	return c.ParentID != nil
+}
+
+// EmailVerified reports whether the customer has verified owning its email
+func (c *Customer) EmailVerified() bool {
+	return c.EmailVerifiedAt != nil
+}
+
+// TwoFactorEnabled reports whether logins of the customer need a second factor
+func (c *Customer) TwoFactorEnabled() bool {
+	return c.TwoFactorEnabledAt != nil
+}
@@ -0,0 +1,70 @@
+package customer
+
+import (
+	"time"
+)
+
+// Scopes of managing the members of an organization
+const (
+	ScopeMembersRead  = "members:read"
+	ScopeMembersWrite = "members:write"
+)
+
+// Roles of organization members, an organization is the account of a customer and the
+// customer itself is always its owner
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleDeveloper = "developer"
+	RoleViewer    = "viewer"
+)
+
+// RoleScopes lists the scopes of every role, owners and admins have every scope but only
+// owners can make or change other owners
+var RoleScopes = map[string]Scopes{
+	RoleOwner: AllScopes,
+	RoleAdmin: AllScopes,
+	RoleDeveloper: {
+		ScopeEndpointsWrite,
+		ScopeEventsRead,
+		ScopeEventsReplay,
+		ScopeDeliveriesRead,
+		ScopeAPIKeysRead,
+		ScopeAPIKeysWrite,
+		ScopeMembersRead,
+		ScopeSubAccountsRead,
+	},
+	RoleViewer: {
+		ScopeEventsRead,
+		ScopeDeliveriesRead,
+		ScopeAPIKeysRead,
+		ScopeMembersRead,
+		ScopeSubAccountsRead,
+	},
+}
+
+// Member gives a customer access to the account of another customer, the organization,
+// with the scopes of its role
+type Member struct {
+	OrganizationID uint64    `json:"organization_id" gorm:"primary_key;autoIncrement:false"`
+	CustomerID     uint64    `json:"customer_id" gorm:"primary_key;autoIncrement:false;index"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// NewMember returns new member of the organization
+func NewMember(organizationID, customerID uint64, role string) *Member {
+	return &Member{
+		OrganizationID: organizationID,
+		CustomerID:     customerID,
+		Role:           role,
+	}
+}
+
+// Invitation invites the owner of the email to join the organization with the role
+type Invitation struct {
+	OrganizationID uint64 `json:"organization_id"`
+	Email          string `json:"email"`
+	Role           string `json:"role"`
+}
@@ -0,0 +1,81 @@
+package customer
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ngavinsir/notification-service/util/password"
+)
+
+// recoveryCodeEncoding encodes recovery codes without padding and ambiguous case
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RecoveryCodes are hashes of the unused recovery codes of a customer, each code can replace
+// a 2fa code once
+type RecoveryCodes []string
+
+// NewRecoveryCodes returns n plain recovery codes and their hashes
+func NewRecoveryCodes(n int) ([]string, RecoveryCodes, error) {
+	codes := make([]string, 0, n)
+	hashes := make(RecoveryCodes, 0, n)
+	for i := 0; i < n; i++ {
+		bytes := make([]byte, 5)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(bytes))
+		code = code[:4] + "-" + code[4:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// Use removes the code from the unused codes, it reports false when the code isn't one of them
+func (r *RecoveryCodes) Use(code string) bool {
+	hash := hashRecoveryCode(code)
+	for i, unused := range *r {
+		if unused == hash {
+			*r = append((*r)[:i:i], (*r)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// hashRecoveryCode hashes the code ignoring case and separators, codes are typed by hand
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return password.HashToken(code)
+}
+
+// Value returns json encoded hashes to be stored in database
+func (r RecoveryCodes) Value() (driver.Value, error) {
+	if r == nil {
+		return "[]", nil
+	}
+	bytes, err := json.Marshal(r)
+	return string(bytes), err
+}
+
+// Scan decodes json encoded hashes from database
+func (r *RecoveryCodes) Scan(value interface{}) error {
+	var bytes []byte
+	switch v := value.(type) {
+	case nil:
+		*r = nil
+		return nil
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return fmt.Errorf("can't scan %T into recovery codes", value)
+	}
+	return json.Unmarshal(bytes, r)
+}
@@ -0,0 +1,49 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of datastore errors, match them with errors.Is
+var (
+	// ErrNotFound is returned when the requested record doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when the change conflicts with the stored state
+	ErrConflict = errors.New("conflict")
+	// ErrDatabase is returned when the database fails
+	ErrDatabase = errors.New("database error")
+	// ErrDuplicateEmail is returned when another customer is registered with the email,
+	// it is also an ErrConflict
+	ErrDuplicateEmail = &Error{Kind: ErrConflict, Message: "email is already registered"}
+)
+
+// Error is a datastore error of a kind, wrapping the error returned by the database
+type Error struct {
+	Kind    error
+	Message string
+	Err     error
+}
+
+// NewError returns new datastore error of the kind with formatted message
+func NewError(kind error, err error, format string, args ...interface{}) *Error {
+	return &Error{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+		Err:     err,
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the error returned by the database
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is the error's kind, or the kind that kind belongs to
+func (e *Error) Is(target error) bool {
+	return target == e.Kind || errors.Is(e.Kind, target)
+}
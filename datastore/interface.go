@@ -2,13 +2,80 @@ package datastore
 
 import (
 	"context"
+	"time"
 
 	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/event"
 )
 
 // CustomerRepository is an interface for customer storage
 type CustomerRepository interface {
+	// Save creates or updates the customer, it fails with ErrDuplicateEmail when another
+	// customer has the same email
 	Save(ctx context.Context, customer *customer.Customer) error
+	// FindByID fails with ErrNotFound when there is no customer with the id
 	FindByID(ctx context.Context, ID uint64) (*customer.Customer, error)
+	// FindByEmail fails with ErrNotFound when there is no customer with the email
 	FindByEmail(ctx context.Context, email string) (*customer.Customer, error)
+	// FindByParentID returns the sub-accounts of the customer, oldest first
+	FindByParentID(ctx context.Context, parentID uint64) ([]*customer.Customer, error)
+}
+
+// APIKeyRepository is an interface for customer's api key storage
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *customer.APIKey) error
+	// FindByHash returns the api key that isn't revoked, it fails with ErrNotFound otherwise
+	FindByHash(ctx context.Context, hash string) (*customer.APIKey, error)
+	// FindByCustomerID returns every api key of the customer including revoked ones
+	FindByCustomerID(ctx context.Context, customerID uint64) ([]*customer.APIKey, error)
+	// Revoke revokes the customer's api key, it fails with ErrNotFound when the customer has
+	// no such key that isn't revoked
+	Revoke(ctx context.Context, customerID, ID uint64) error
+	// RevokeByCreator revokes every api key of the customers created by the member
+	RevokeByCreator(ctx context.Context, customerIDs []uint64, createdBy uint64) error
+	// Touch records that the api key is used at t
+	Touch(ctx context.Context, ID uint64, t time.Time) error
+}
+
+// MemberRepository is an interface for organization member storage
+type MemberRepository interface {
+	// Create fails with ErrConflict when the customer is already a member of the organization
+	Create(ctx context.Context, member *customer.Member) error
+	// Find fails with ErrNotFound when the customer isn't a member of the organization
+	Find(ctx context.Context, organizationID, customerID uint64) (*customer.Member, error)
+	// FindByOrganizationID returns the members of the organization, oldest first
+	FindByOrganizationID(ctx context.Context, organizationID uint64) ([]*customer.Member, error)
+	// FindByCustomerID returns the memberships of the customer, oldest first
+	FindByCustomerID(ctx context.Context, customerID uint64) ([]*customer.Member, error)
+	// Save updates the member's role
+	Save(ctx context.Context, member *customer.Member) error
+	// Delete fails with ErrNotFound when the customer isn't a member of the organization
+	Delete(ctx context.Context, organizationID, customerID uint64) error
+}
+
+// EventRepository is an interface for inbound event storage
+type EventRepository interface {
+	// Create stores the event together with its outbox entry atomically
+	Create(ctx context.Context, event *event.Event) error
+	// RelayOutbox passes up to limit unpublished events to publish, oldest first, and marks
+	// the ones that are published successfully. It stops at the first failed publish and
+	// returns the number of published events.
+	RelayOutbox(ctx context.Context, limit int, publish func(event *event.Event) error) (int, error)
+	// Archive stores the event without outbox entry, for events that won't be delivered
+	Archive(ctx context.Context, event *event.Event) error
+	// Assign moves an orphaned event to the customer and adds its outbox entry atomically
+	Assign(ctx context.Context, ID, customerID uint64) (*event.Event, error)
+	FindByID(ctx context.Context, ID uint64) (*event.Event, error)
+	Find(ctx context.Context, filter *event.Filter) ([]*event.Event, error)
+	// DeleteReceivedBefore deletes events received before t together with their outbox
+	// entries and deliveries, and returns the number of deleted events
+	DeleteReceivedBefore(ctx context.Context, t time.Time) (int64, error)
+}
+
+// DeliveryRepository is an interface for outbound delivery storage
+type DeliveryRepository interface {
+	Create(ctx context.Context, delivery *event.Delivery) error
+	// Find returns deliveries matching the customer, status and time range of the filter,
+	// newest first
+	Find(ctx context.Context, filter *event.Filter) ([]*event.Delivery, error)
 }
@@ -0,0 +1,107 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/datastore"
+	"gorm.io/gorm"
+)
+
+// NewAPIKeyRepository returns new api key repository
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	r := &APIKeyRepository{
+		DB: db,
+	}
+
+	return r
+}
+
+// APIKeyRepository stores customer's api keys
+type APIKeyRepository struct {
+	DB *gorm.DB
+}
+
+// Create stores the api key
+func (r *APIKeyRepository) Create(ctx context.Context, key *customer.APIKey) error {
+	if err := r.DB.WithContext(ctx).Create(key).Error; err != nil {
+		return datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+	return nil
+}
+
+// FindByHash returns api key that isn't revoked by its hash
+func (r *APIKeyRepository) FindByHash(ctx context.Context, hash string) (*customer.APIKey, error) {
+	var key customer.APIKey
+
+	req := r.DB.WithContext(ctx).
+		Where("hash = ? AND revoked_at IS NULL", hash).
+		First(&key)
+	if errors.Is(req.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.NewError(datastore.ErrNotFound, req.Error, "can't find api key")
+	}
+	if req.Error != nil {
+		return nil, datastore.NewError(datastore.ErrDatabase, req.Error, "database error")
+	}
+
+	return &key, nil
+}
+
+// FindByCustomerID returns api keys of the customer, oldest first
+func (r *APIKeyRepository) FindByCustomerID(ctx context.Context, customerID uint64) ([]*customer.APIKey, error) {
+	var keys []*customer.APIKey
+
+	err := r.DB.WithContext(ctx).
+		Where("customer_id = ?", customerID).
+		Order("id").
+		Find(&keys).
+		Error
+	if err != nil {
+		return nil, datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+
+	return keys, nil
+}
+
+// Revoke marks the customer's api key revoked
+func (r *APIKeyRepository) Revoke(ctx context.Context, customerID, ID uint64) error {
+	req := r.DB.WithContext(ctx).
+		Model(&customer.APIKey{}).
+		Where("id = ? AND customer_id = ? AND revoked_at IS NULL", ID, customerID).
+		Update("revoked_at", time.Now())
+	if req.Error != nil {
+		return datastore.NewError(datastore.ErrDatabase, req.Error, "database error")
+	}
+	if req.RowsAffected == 0 {
+		return datastore.NewError(datastore.ErrNotFound, nil, "can't find api key with id: %d", ID)
+	}
+	return nil
+}
+
+// RevokeByCreator marks the api keys of the customers created by the member revoked
+func (r *APIKeyRepository) RevokeByCreator(ctx context.Context, customerIDs []uint64, createdBy uint64) error {
+	err := r.DB.WithContext(ctx).
+		Model(&customer.APIKey{}).
+		Where("customer_id IN ? AND created_by = ? AND revoked_at IS NULL", customerIDs, createdBy).
+		Update("revoked_at", time.Now()).
+		Error
+	if err != nil {
+		return datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+	return nil
+}
+
+// Touch updates last used time of the api key
+func (r *APIKeyRepository) Touch(ctx context.Context, ID uint64, t time.Time) error {
+	err := r.DB.WithContext(ctx).
+		Model(&customer.APIKey{}).
+		Where("id = ?", ID).
+		Update("last_used_at", t).
+		Error
+	if err != nil {
+		return datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+	return nil
+}
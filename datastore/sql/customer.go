@@ -2,12 +2,18 @@ package sql
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"strings"
 
+	"github.com/jackc/pgconn"
 	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/datastore"
 	"gorm.io/gorm"
 )
 
+// uniqueViolation is postgres error code of unique constraint violation
+const uniqueViolation = "23505"
+
 // NewCustomerRepository returns new customer respository
 func NewCustomerRepository(db *gorm.DB) *CustomerRepository {
 	r := &CustomerRepository{
@@ -28,8 +34,11 @@ func (r *CustomerRepository) Save(ctx context.Context, customer *customer.Custom
 		Save(customer).
 		Find(&customer).
 		Error
+	if isUniqueViolation(err, "email") {
+		return datastore.NewError(datastore.ErrDuplicateEmail, err, "email %s is already registered", customer.Email)
+	}
 	if err != nil {
-		return fmt.Errorf("database error")
+		return datastore.NewError(datastore.ErrDatabase, err, "database error")
 	}
 	return nil
 }
@@ -42,8 +51,11 @@ func (r *CustomerRepository) FindByID(ctx context.Context, ID uint64) (*customer
 		Preload("Callback").
 		Where("id = ?", ID).
 		First(&customer)
+	if errors.Is(req.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.NewError(datastore.ErrNotFound, req.Error, "can't find customer with id: %d", ID)
+	}
 	if req.Error != nil {
-		return nil, fmt.Errorf("can't find customer with id: %d", ID)
+		return nil, datastore.NewError(datastore.ErrDatabase, req.Error, "database error")
 	}
 
 	return &customer, nil
@@ -57,9 +69,35 @@ func (r *CustomerRepository) FindByEmail(ctx context.Context, email string) (*cu
 		Preload("Callback").
 		Where("email = ?", email).
 		First(&customer)
+	if errors.Is(req.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.NewError(datastore.ErrNotFound, req.Error, "can't find customer with email: %s", email)
+	}
 	if req.Error != nil {
-		return nil, fmt.Errorf("can't find customer with email: %s", email)
+		return nil, datastore.NewError(datastore.ErrDatabase, req.Error, "database error")
 	}
 
 	return &customer, nil
 }
+
+// FindByParentID returns sub-accounts of the customer, oldest first
+func (r *CustomerRepository) FindByParentID(ctx context.Context, parentID uint64) ([]*customer.Customer, error) {
+	var customers []*customer.Customer
+
+	err := r.DB.WithContext(ctx).
+		Preload("Callback").
+		Where("parent_id = ?", parentID).
+		Order("id").
+		Find(&customers).
+		Error
+	if err != nil {
+		return nil, datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+
+	return customers, nil
+}
+
+// isUniqueViolation reports whether err violates a unique constraint on the column
+func isUniqueViolation(err error, column string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && strings.Contains(pgErr.ConstraintName, column)
+}
@@ -0,0 +1,63 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/ngavinsir/notification-service/datastore"
+	"github.com/ngavinsir/notification-service/event"
+	"gorm.io/gorm"
+)
+
+// NewDeliveryRepository returns new delivery repository
+func NewDeliveryRepository(db *gorm.DB) *DeliveryRepository {
+	r := &DeliveryRepository{
+		DB: db,
+	}
+
+	return r
+}
+
+// DeliveryRepository stores outbound delivery attempts
+type DeliveryRepository struct {
+	DB *gorm.DB
+}
+
+// Create stores the delivery attempt
+func (r *DeliveryRepository) Create(ctx context.Context, delivery *event.Delivery) error {
+	if err := r.DB.WithContext(ctx).Create(delivery).Error; err != nil {
+		return datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+	return nil
+}
+
+// Find returns deliveries matching the filter, newest first
+func (r *DeliveryRepository) Find(ctx context.Context, filter *event.Filter) ([]*event.Delivery, error) {
+	query := r.DB.WithContext(ctx)
+	if filter.CustomerID != 0 {
+		query = query.Where("customer_id = ?", filter.CustomerID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var deliveries []*event.Delivery
+	err := query.
+		Offset(filter.Offset).
+		Order("created_at DESC, id DESC").
+		Find(&deliveries).
+		Error
+	if err != nil {
+		return nil, datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+
+	return deliveries, nil
+}
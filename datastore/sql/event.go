@@ -0,0 +1,214 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ngavinsir/notification-service/datastore"
+	"github.com/ngavinsir/notification-service/event"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewEventRepository returns new event repository
+func NewEventRepository(db *gorm.DB) *EventRepository {
+	r := &EventRepository{
+		DB: db,
+	}
+
+	return r
+}
+
+// EventRepository stores inbound events and their outbox entries
+type EventRepository struct {
+	DB *gorm.DB
+}
+
+// Create stores the event and its outbox entry in one transaction
+func (r *EventRepository) Create(ctx context.Context, e *event.Event) error {
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(e).Error; err != nil {
+			return err
+		}
+		return tx.Create(&event.Outbox{EventID: e.ID}).Error
+	})
+	if err != nil {
+		return datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+	return nil
+}
+
+// RelayOutbox locks unpublished outbox entries so concurrent relays skip them, and marks
+// the entries published in the same transaction
+func (r *EventRepository) RelayOutbox(
+	ctx context.Context,
+	limit int,
+	publish func(e *event.Event) error,
+) (int, error) {
+	published := 0
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var entries []*event.Outbox
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Preload("Event").
+			Where("published_at IS NULL").
+			Order("id").
+			Limit(limit).
+			Find(&entries).
+			Error
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := publish(entry.Event); err != nil {
+				return tx.Model(entry).Updates(map[string]interface{}{
+					"attempts":   gorm.Expr("attempts + 1"),
+					"last_error": err.Error(),
+				}).Error
+			}
+
+			now := time.Now()
+			if err := tx.Model(entry).Update("published_at", &now).Error; err != nil {
+				return err
+			}
+			published++
+		}
+		return nil
+	})
+	if err != nil {
+		return published, datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+	return published, nil
+}
+
+// Archive stores the event without outbox entry
+func (r *EventRepository) Archive(ctx context.Context, e *event.Event) error {
+	if err := r.DB.WithContext(ctx).Create(e).Error; err != nil {
+		return datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+	return nil
+}
+
+// Assign moves an orphaned event to the customer and adds its outbox entry in one transaction
+func (r *EventRepository) Assign(ctx context.Context, ID, customerID uint64) (*event.Event, error) {
+	var e event.Event
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND status = ?", ID, event.StatusOrphaned).
+			First(&e).
+			Error
+		if err != nil {
+			return err
+		}
+
+		e.CustomerID = customerID
+		e.Status = event.StatusAccepted
+		e.Error = ""
+		if err := tx.Model(&e).Select("customer_id", "status", "error").Updates(&e).Error; err != nil {
+			return err
+		}
+		return tx.Create(&event.Outbox{EventID: e.ID}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, datastore.NewError(datastore.ErrConflict, err, "event with id %d is not orphaned", ID)
+	}
+	if err != nil {
+		return nil, datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+
+	return &e, nil
+}
+
+// FindByID returns event by id with its deliveries
+func (r *EventRepository) FindByID(ctx context.Context, ID uint64) (*event.Event, error) {
+	var e event.Event
+
+	req := r.DB.WithContext(ctx).
+		Preload("Deliveries", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id")
+		}).
+		Where("id = ?", ID).
+		First(&e)
+	if errors.Is(req.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.NewError(datastore.ErrNotFound, req.Error, "can't find event with id: %d", ID)
+	}
+	if req.Error != nil {
+		return nil, datastore.NewError(datastore.ErrDatabase, req.Error, "database error")
+	}
+
+	return &e, nil
+}
+
+// Find returns events matching the filter, newest first
+func (r *EventRepository) Find(ctx context.Context, filter *event.Filter) ([]*event.Event, error) {
+	query := r.DB.WithContext(ctx)
+	if filter.CustomerID != 0 {
+		query = query.Where("customer_id = ?", filter.CustomerID)
+	}
+	if filter.Provider != "" {
+		query = query.Where("provider = ?", filter.Provider)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if len(filter.PaymentIDs) > 0 {
+		query = query.Where("payload->>'payment_id' IN ?", filter.PaymentIDs)
+	}
+	if filter.DeadLettered {
+		lastStatus := r.DB.
+			Model(&event.Delivery{}).
+			Select("status").
+			Where("deliveries.event_id = events.id AND deliveries.customer_id = events.customer_id").
+			Order("deliveries.id DESC").
+			Limit(1)
+		query = query.Where("(?) = ?", lastStatus, event.DeliveryDeadLettered)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("received_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("received_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var events []*event.Event
+	err := query.
+		Offset(filter.Offset).
+		Order("received_at DESC, id DESC").
+		Find(&events).
+		Error
+	if err != nil {
+		return nil, datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+
+	return events, nil
+}
+
+// DeleteReceivedBefore deletes events received before t with their outbox entries and deliveries
+func (r *EventRepository) DeleteReceivedBefore(ctx context.Context, t time.Time) (int64, error) {
+	var deleted int64
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(&event.Event{}).Select("id").Where("received_at < ?", t)
+
+		if err := tx.Where("event_id IN (?)", expired).Delete(&event.Delivery{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("event_id IN (?)", expired).Delete(&event.Outbox{}).Error; err != nil {
+			return err
+		}
+
+		req := tx.Where("received_at < ?", t).Delete(&event.Event{})
+		deleted = req.RowsAffected
+		return req.Error
+	})
+	if err != nil {
+		return 0, datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,100 @@
+package sql
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/datastore"
+	"gorm.io/gorm"
+)
+
+// NewMemberRepository returns new member repository
+func NewMemberRepository(db *gorm.DB) *MemberRepository {
+	r := &MemberRepository{
+		DB: db,
+	}
+
+	return r
+}
+
+// MemberRepository stores organization members
+type MemberRepository struct {
+	DB *gorm.DB
+}
+
+// Create stores the member
+func (r *MemberRepository) Create(ctx context.Context, member *customer.Member) error {
+	err := r.DB.WithContext(ctx).Create(member).Error
+	if isUniqueViolation(err, "pkey") {
+		return datastore.NewError(datastore.ErrConflict, err, "customer %d is already a member", member.CustomerID)
+	}
+	if err != nil {
+		return datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+	return nil
+}
+
+// Find returns the membership of the customer in the organization
+func (r *MemberRepository) Find(ctx context.Context, organizationID, customerID uint64) (*customer.Member, error) {
+	var member customer.Member
+
+	req := r.DB.WithContext(ctx).
+		Where("organization_id = ? AND customer_id = ?", organizationID, customerID).
+		First(&member)
+	if errors.Is(req.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.NewError(datastore.ErrNotFound, req.Error, "can't find member %d of organization %d", customerID, organizationID)
+	}
+	if req.Error != nil {
+		return nil, datastore.NewError(datastore.ErrDatabase, req.Error, "database error")
+	}
+
+	return &member, nil
+}
+
+// FindByOrganizationID returns members of the organization, oldest first
+func (r *MemberRepository) FindByOrganizationID(ctx context.Context, organizationID uint64) ([]*customer.Member, error) {
+	return r.find(ctx, "organization_id = ?", organizationID)
+}
+
+// FindByCustomerID returns memberships of the customer, oldest first
+func (r *MemberRepository) FindByCustomerID(ctx context.Context, customerID uint64) ([]*customer.Member, error) {
+	return r.find(ctx, "customer_id = ?", customerID)
+}
+
+func (r *MemberRepository) find(ctx context.Context, query string, args ...interface{}) ([]*customer.Member, error) {
+	var members []*customer.Member
+
+	err := r.DB.WithContext(ctx).
+		Where(query, args...).
+		Order("created_at").
+		Find(&members).
+		Error
+	if err != nil {
+		return nil, datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+
+	return members, nil
+}
+
+// Save updates the member
+func (r *MemberRepository) Save(ctx context.Context, member *customer.Member) error {
+	if err := r.DB.WithContext(ctx).Save(member).Error; err != nil {
+		return datastore.NewError(datastore.ErrDatabase, err, "database error")
+	}
+	return nil
+}
+
+// Delete removes the customer from the organization
+func (r *MemberRepository) Delete(ctx context.Context, organizationID, customerID uint64) error {
+	req := r.DB.WithContext(ctx).
+		Where("organization_id = ? AND customer_id = ?", organizationID, customerID).
+		Delete(&customer.Member{})
+	if req.Error != nil {
+		return datastore.NewError(datastore.ErrDatabase, req.Error, "database error")
+	}
+	if req.RowsAffected == 0 {
+		return datastore.NewError(datastore.ErrNotFound, nil, "can't find member %d of organization %d", customerID, organizationID)
+	}
+	return nil
+}
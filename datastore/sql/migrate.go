@@ -0,0 +1,39 @@
+package sql
+
+import (
+	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/event"
+	"gorm.io/gorm"
+)
+
+// Migrate creates and updates the tables of every model. Customers registered before email
+// verification was required are marked verified since their creation when the column is
+// added, so only new registrations start unverified and existing customers keep getting
+// their payment callbacks.
+func Migrate(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		backfillVerification := tx.Migrator().HasTable(&customer.Customer{}) &&
+			!tx.Migrator().HasColumn(&customer.Customer{}, "EmailVerifiedAt")
+
+		err := tx.AutoMigrate(
+			&customer.Customer{},
+			&customer.Callback{},
+			&customer.APIKey{},
+			&customer.Member{},
+			&event.Event{},
+			&event.Outbox{},
+			&event.Delivery{},
+		)
+		if err != nil {
+			return err
+		}
+
+		if backfillVerification {
+			return tx.Model(&customer.Customer{}).
+				Where("email_verified_at IS NULL").
+				Update("email_verified_at", gorm.Expr("created_at")).
+				Error
+		}
+		return nil
+	})
+}
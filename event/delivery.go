@@ -0,0 +1,25 @@
+package event
+
+import (
+	"time"
+)
+
+// Delivery statuses
+const (
+	DeliverySucceeded    = "succeeded"
+	DeliveryFailed       = "failed"
+	DeliveryDeadLettered = "dead_lettered"
+)
+
+// Delivery stores an attempt to notify the customer of an event
+type Delivery struct {
+	ID         uint64    `json:"id" gorm:"primary_key"`
+	EventID    uint64    `json:"event_id" gorm:"index"`
+	CustomerID uint64    `json:"customer_id" gorm:"index"`
+	Channel    string    `json:"channel"`
+	Attempt    int       `json:"attempt"`
+	Status     string    `json:"status"`
+	Replay     bool      `json:"replay"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,99 @@
+package event
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Providers that send inbound callbacks
+const (
+	ProviderAlfamart = "alfamart"
+)
+
+// Types of inbound events
+const (
+	TypePaymentPaid = "payment.paid"
+)
+
+// Parse results of inbound events
+const (
+	StatusAccepted = "accepted"
+	StatusInvalid  = "invalid"
+	// StatusOrphaned is an event of unknown customer, parked until it is assigned to a customer
+	StatusOrphaned = "orphaned"
+)
+
+// Event stores an inbound callback received from a payment provider, including the raw
+// request so disputes about what the provider sent can be investigated
+type Event struct {
+	ID         uint64          `json:"id" gorm:"primary_key"`
+	Provider   string          `json:"provider" gorm:"index"`
+	Type       string          `json:"type"`
+	CustomerID uint64          `json:"customer_id" gorm:"index"`
+	Payload    json.RawMessage `json:"payload" gorm:"type:jsonb"`
+	RawBody    []byte          `json:"raw_body"`
+	Headers    Headers         `json:"headers" gorm:"type:jsonb"`
+	SourceIP   string          `json:"source_ip"`
+	Status     string          `json:"status" gorm:"index"`
+	Error      string          `json:"error,omitempty"`
+	ReceivedAt time.Time       `json:"received_at" gorm:"index"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Deliveries []*Delivery     `json:"deliveries,omitempty"`
+}
+
+// New returns new inbound event
+func New(provider, eventType string, customerID uint64, payload json.RawMessage) *Event {
+	return &Event{
+		Provider:   provider,
+		Type:       eventType,
+		CustomerID: customerID,
+		Payload:    payload,
+		Status:     StatusAccepted,
+		ReceivedAt: time.Now(),
+	}
+}
+
+// Filter narrows down events returned by event queries
+type Filter struct {
+	CustomerID uint64
+	Provider   string
+	Status     string
+	PaymentIDs []string
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+	// DeadLettered selects events whose last delivery to their customer is dead-lettered
+	DeadLettered bool
+}
+
+// Headers stores http headers of an inbound request
+type Headers map[string][]string
+
+// Value returns json encoded headers to be stored in database
+func (h Headers) Value() (driver.Value, error) {
+	if h == nil {
+		return nil, nil
+	}
+	bytes, err := json.Marshal(h)
+	return string(bytes), err
+}
+
+// Scan decodes json encoded headers from database
+func (h *Headers) Scan(value interface{}) error {
+	var bytes []byte
+	switch v := value.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return fmt.Errorf("can't scan %T into headers", value)
+	}
+	return json.Unmarshal(bytes, h)
+}
@@ -0,0 +1,18 @@
+package event
+
+import (
+	"time"
+)
+
+// Outbox stores an event that is waiting to be published to the delivery workers, it is
+// written in the same transaction as the event
+type Outbox struct {
+	ID          uint64     `json:"id" gorm:"primary_key"`
+	EventID     uint64     `json:"event_id" gorm:"index"`
+	Event       *Event     `json:"-"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error"`
+	PublishedAt *time.Time `json:"published_at" gorm:"index"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
@@ -4,10 +4,17 @@ go 1.14
 
 require (
 	github.com/abraithwaite/jeff v0.1.0
+	github.com/alicebob/miniredis/v2 v2.30.0
 	github.com/go-chi/chi v1.5.3
 	github.com/go-chi/render v1.0.1
+	github.com/go-playground/validator/v10 v10.4.1
+	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/gomodule/redigo v2.0.0+incompatible
+	github.com/jackc/pgconn v1.8.0
 	github.com/jackc/pgproto3/v2 v2.0.7 // indirect
+	github.com/nats-io/nats-server/v2 v2.1.9
+	github.com/nats-io/nats.go v1.10.0
+	github.com/streadway/amqp v1.0.0
 	github.com/tinylib/msgp v1.1.5 // indirect
 	golang.org/x/crypto v0.0.0-20210220033148-5ea612d1eb83
 	golang.org/x/text v0.3.5 // indirect
@@ -1,29 +1,130 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/ngavinsir/notification-service/customer"
+	dssql "github.com/ngavinsir/notification-service/datastore/sql"
 	"github.com/ngavinsir/notification-service/server"
 	"github.com/ngavinsir/notification-service/util/sql"
 )
 
+// Usage: notification-service [serve|worker|replay]
+//
+//	serve   serves the HTTP API and runs the outbox relay and NOTIFIER_WORKERS notifier
+//	        workers (default)
+//	worker  only runs the outbox relay and NOTIFIER_WORKERS notifier workers
+//	replay  replays stored events of a customer, see replay -h
 func main() {
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
 	db := sql.NewGorm()
-	db.AutoMigrate(
-		&customer.Customer{},
-		&customer.Callback{},
-	)
+	if err := dssql.Migrate(db); err != nil {
+		log.Fatalf("database migration failed: %v", err)
+	}
 
 	server := server.NewServer(db)
 
-	port := ":4040"
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		port = ":" + envPort
+	switch command {
+	case "serve":
+		workers := runBackground(server)
+
+		port := ":4040"
+		if envPort := os.Getenv("PORT"); envPort != "" {
+			port = ":" + envPort
+		}
+
+		log.Printf("Server started on %s with %d notifier workers", port, workers)
+		log.Fatal(http.ListenAndServe(port, server.Router()))
+	case "worker":
+		workers := runBackground(server)
+		log.Printf("Started outbox relay and %d notifier workers", workers)
+		select {}
+	case "replay":
+		replay(server, os.Args[2:])
+	default:
+		log.Fatalf("unknown command: %s", command)
+	}
+}
+
+// runBackground starts the outbox relay, event retention and notifier workers, it returns
+// the number of started workers
+func runBackground(server *server.Server) int {
+	workers := 4
+	if envWorkers := os.Getenv("NOTIFIER_WORKERS"); envWorkers != "" {
+		n, err := strconv.Atoi(envWorkers)
+		if err != nil {
+			log.Fatalf("invalid NOTIFIER_WORKERS: %v", err)
+		}
+		workers = n
+	}
+
+	retention := 90 * 24 * time.Hour
+	if envRetention := os.Getenv("EVENT_RETENTION"); envRetention != "" {
+		d, err := time.ParseDuration(envRetention)
+		if err != nil {
+			log.Fatalf("invalid EVENT_RETENTION: %v", err)
+		}
+		retention = d
+	}
+	if retention > 0 {
+		go server.RunRetention(context.Background(), retention)
 	}
 
-	log.Printf("Server started on %s", port)
-	log.Fatal(http.ListenAndServe(port, server.Router()))
+	go server.OutboxRelay.Run(context.Background())
+	server.RunWorkers(context.Background(), workers)
+
+	return workers
+}
+
+func replay(s *server.Server, args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	customerID := flags.Uint64("customer", 0, "id of the customer whose events are replayed (required)")
+	from := flags.String("from", "", "replay events received at or after this RFC3339 time")
+	to := flags.String("to", "", "replay events received before this RFC3339 time")
+	paymentIDs := flags.String("payment-ids", "", "comma separated payment ids to replay")
+	flags.Parse(args)
+
+	req := &server.ReplayEventsRequest{}
+	if *from != "" {
+		t, err := time.Parse(time.RFC3339, *from)
+		if err != nil {
+			log.Fatalf("invalid -from: %v", err)
+		}
+		req.From = &t
+	}
+	if *to != "" {
+		t, err := time.Parse(time.RFC3339, *to)
+		if err != nil {
+			log.Fatalf("invalid -to: %v", err)
+		}
+		req.To = &t
+	}
+	if *paymentIDs != "" {
+		req.PaymentIDs = strings.Split(*paymentIDs, ",")
+	}
+	if *customerID == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	filter, err := req.Filter(*customerID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	replayed, err := s.ReplayEvents(context.Background(), filter)
+	if err != nil {
+		log.Fatalf("replayed %d events before error: %v", replayed, err)
+	}
+	log.Printf("Replayed %d events of customer %d", replayed, *customerID)
 }
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/ngavinsir/notification-service/event"
+)
+
+const (
+	defaultEventsLimit = 50
+	maxEventsLimit     = 500
+)
+
+// AdminAuth only lets requests with "Authorization: Bearer <admin api key>" through, admin
+// endpoints are disabled when admin api key is not set
+func (s *Server) AdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if s.AdminAPIKey == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminAPIKey)) != 1 {
+			render.Render(w, r, ErrUnauthorized(fmt.Errorf("invalid admin api key")))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminListEventsHandler handles request for querying archived inbound events
+func (s *Server) AdminListEventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseEventFilter(r)
+		if err != nil {
+			render.Render(w, r, ErrBadRequest(err))
+			return
+		}
+
+		events, err := s.EventRepository.Find(r.Context(), filter)
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+
+		render.JSON(w, r, events)
+	}
+}
+
+// AdminGetEventHandler handles request for reading an inbound event with its deliveries
+func (s *Server) AdminGetEventHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventID, err := strconv.ParseUint(chi.URLParam(r, "eventID"), 10, 64)
+		if err != nil {
+			render.Render(w, r, ErrBadRequest(fmt.Errorf("invalid event id")))
+			return
+		}
+
+		inboundEvent, err := s.EventRepository.FindByID(r.Context(), eventID)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.JSON(w, r, inboundEvent)
+	}
+}
+
+// AdminAssignEventHandler handles request for assigning an orphaned event to a customer and
+// delivering it
+func (s *Server) AdminAssignEventHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventID, err := strconv.ParseUint(chi.URLParam(r, "eventID"), 10, 64)
+		if err != nil {
+			render.Render(w, r, ErrBadRequest(fmt.Errorf("invalid event id")))
+			return
+		}
+
+		var req AssignEventRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		orphanedEvent, err := s.EventRepository.FindByID(r.Context(), eventID)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if orphanedEvent.Status != event.StatusOrphaned {
+			render.Render(w, r, ErrConflict(fmt.Errorf("event with id %d is not orphaned", eventID)))
+			return
+		}
+
+		if _, err := s.CustomerRepository.FindByID(r.Context(), req.CustomerID); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		assignedEvent, err := s.EventRepository.Assign(r.Context(), eventID, req.CustomerID)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		s.OutboxRelay.Wake()
+
+		render.JSON(w, r, assignedEvent)
+	}
+}
+
+// AssignEventRequest is a struct for assign event endpoint's request body
+type AssignEventRequest struct {
+	CustomerID uint64 `json:"customer_id" validate:"required"`
+}
+
+func parseEventFilter(r *http.Request) (*event.Filter, error) {
+	query := r.URL.Query()
+	filter := &event.Filter{
+		Provider: query.Get("provider"),
+		Status:   query.Get("status"),
+		Limit:    defaultEventsLimit,
+	}
+
+	var err error
+	if value := query.Get("customer_id"); value != "" {
+		if filter.CustomerID, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid customer_id")
+		}
+	}
+	if value := query.Get("from"); value != "" {
+		if filter.From, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("from must be RFC3339 time")
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if filter.To, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("to must be RFC3339 time")
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 || filter.Limit > maxEventsLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxEventsLimit)
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if filter.Offset, err = strconv.Atoi(value); err != nil || filter.Offset < 0 {
+			return nil, fmt.Errorf("invalid offset")
+		}
+	}
+
+	return filter, nil
+}
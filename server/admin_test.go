@@ -0,0 +1,181 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ngavinsir/notification-service/event"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestServer_AlfamartPaymentCallback_ArchivesInvalidRequest(t *testing.T) {
+	server := setupMockServer()
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/alfamart_payment_callback", bytes.NewBufferString(`{"payment_id": `))
+	req.Header.Set("Authorization", "secret")
+	req.Header.Set("X-Signature", "abc")
+	server.AlfamartPaymentCallbackHandler().ServeHTTP(rr, req)
+
+	if got, want := rr.Code, http.StatusBadRequest; got != want {
+		t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+	}
+
+	archived, err := server.EventRepository.FindByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := archived.Status, event.StatusInvalid; got != want {
+		t.Errorf("Want archived event status %s, got %s", want, got)
+	}
+	if got, want := string(archived.RawBody), `{"payment_id": `; got != want {
+		t.Errorf("Want archived raw body %s, got %s", want, got)
+	}
+	if got, want := archived.Headers["X-Signature"], []string{"abc"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("Want archived header %v, got %v", want, got)
+	}
+	if got, want := archived.Headers["Authorization"], "[redacted]"; len(got) != 1 || got[0] != want {
+		t.Errorf("Want authorization header %s, got %v", want, got)
+	}
+	if got, want := archived.SourceIP, "192.0.2.1"; got != want {
+		t.Errorf("Want archived source ip %s, got %s", want, got)
+	}
+}
+
+func TestServer_AdminEvents(t *testing.T) {
+	server := setupMockServer()
+	router := server.Router()
+
+	inboundEvent := event.New(event.ProviderAlfamart, event.TypePaymentPaid, 1, json.RawMessage(`{}`))
+	if err := server.EventRepository.Archive(context.Background(), inboundEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	adminRequest := func(url, apiKey string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Response Error Unauthorized", func(t *testing.T) {
+		if got, want := adminRequest("/admin/events", "wrong-key").Code, http.StatusUnauthorized; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("List events", func(t *testing.T) {
+		rr := adminRequest("/admin/events?customer_id=1", "admin-key")
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		var events []*event.Event
+		if err := json.NewDecoder(rr.Body).Decode(&events); err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 || events[0].ID != inboundEvent.ID {
+			t.Errorf("Want event %d listed, got %v", inboundEvent.ID, events)
+		}
+	})
+
+	t.Run("Invalid filter", func(t *testing.T) {
+		if got, want := adminRequest("/admin/events?from=yesterday", "admin-key").Code, http.StatusBadRequest; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Event not found", func(t *testing.T) {
+		if got, want := adminRequest("/admin/events/100", "admin-key").Code, http.StatusNotFound; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestServer_AlfamartPaymentCallback_UnknownCustomer(t *testing.T) {
+	server := setupMockServer()
+	alfamartRequest := &AlfamartPaymentCallbackRequest{
+		PaymentID:   "123123123",
+		PaymentCode: "XYZ123",
+		PaidAt:      time.Now(),
+		ExternalID:  "order-123",
+		CustomerID:  1,
+	}
+
+	t.Run("Rejected by default", func(t *testing.T) {
+		response, err := sendRequest(server.AlfamartPaymentCallbackHandler(), "POST", "/alfamart_payment_callback", alfamartRequest, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := response.StatusCode, http.StatusNotFound; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Accepted and parked", func(t *testing.T) {
+		server.OrphanPolicy = OrphanPolicyPark
+		response, err := sendRequest(server.AlfamartPaymentCallbackHandler(), "POST", "/alfamart_payment_callback", alfamartRequest, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := response.StatusCode, http.StatusAccepted; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	orphanedEvents, err := server.EventRepository.Find(context.Background(), &event.Filter{Status: event.StatusOrphaned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(orphanedEvents), 2; got != want {
+		t.Fatalf("Want %d orphaned events, got %d", want, got)
+	}
+
+	t.Run("Assigned to customer and delivered", func(t *testing.T) {
+		delivered := make(chan AlfamartPaymentCallbackRequest, 1)
+		mockCustomerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req AlfamartPaymentCallbackRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			delivered <- req
+			w.Write([]byte(`OK`))
+		}))
+		defer mockCustomerServer.Close()
+
+		if err := mustRegisterVerified(server, "example@example.com", "password"); err != nil {
+			t.Fatal(err)
+		}
+		loginResponse, err := mustLogin(server.LoginHandler(), "example@example.com", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = mustSetCallbackURL(server.Jeff.WrapFunc(server.SetCallbackURLHandler()), mockCustomerServer.URL, loginResponse.Cookies())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		url := fmt.Sprintf("/admin/events/%d/assign", orphanedEvents[0].ID)
+		req := httptest.NewRequest("POST", url, bytes.NewBufferString(`{"customer_id": 1}`))
+		req.Header.Set("Authorization", "Bearer admin-key")
+		server.Router().ServeHTTP(rr, req)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		select {
+		case got := <-delivered:
+			if got.PaymentID != alfamartRequest.PaymentID {
+				t.Errorf("Want delivered payment %s, got %s", alfamartRequest.PaymentID, got.PaymentID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("assigned event isn't delivered")
+		}
+	})
+}
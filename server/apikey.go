@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abraithwaite/jeff"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/datastore"
+)
+
+type contextKey int
+
+const (
+	customerContextKey contextKey = iota
+	apiKeyContextKey
+	accessClaimsContextKey
+	organizationContextKey
+	memberContextKey
+)
+
+// Authenticate lets requests with "Authorization: Bearer <api key or access token>" of a key
+// or token that isn't revoked through, requests without authorization header need a session
+// cookie instead
+func (s *Server) Authenticate(next http.Handler) http.Handler {
+	sessionAuth := s.Jeff.Wrap(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if authorization == "" {
+			sessionAuth.ServeHTTP(w, r)
+			return
+		}
+
+		key := strings.TrimPrefix(authorization, "Bearer ")
+		if !strings.HasPrefix(key, customer.APIKeyPrefix) {
+			s.authenticateAccessToken(next, w, r, key)
+			return
+		}
+
+		apiKey, err := s.APIKeyRepository.FindByHash(r.Context(), customer.HashAPIKey(key))
+		if errors.Is(err, datastore.ErrNotFound) {
+			render.Render(w, r, ErrUnauthorized(fmt.Errorf("invalid api key")))
+			return
+		}
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+
+		keyOwner, err := s.CustomerRepository.FindByID(r.Context(), apiKey.CustomerID)
+		if errors.Is(err, datastore.ErrNotFound) {
+			render.Render(w, r, ErrUnauthorized(fmt.Errorf("invalid api key")))
+			return
+		}
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+
+		if err := s.APIKeyRepository.Touch(r.Context(), apiKey.ID, time.Now()); err != nil {
+			log.Printf("error when records usage of api key %d, error: %v", apiKey.ID, err)
+		}
+
+		ctx := context.WithValue(r.Context(), customerContextKey, keyOwner)
+		ctx = context.WithValue(ctx, apiKeyContextKey, apiKey)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticatedCustomer returns the customer authenticated by api key or by session
+func (s *Server) authenticatedCustomer(r *http.Request) (*customer.Customer, error) {
+	if keyOwner, ok := r.Context().Value(customerContextKey).(*customer.Customer); ok {
+		return keyOwner, nil
+	}
+
+	sess := jeff.ActiveSession(r.Context())
+	return s.CustomerRepository.FindByEmail(r.Context(), string(sess.Key))
+}
+
+// CreateAPIKeyHandler handles request for creating an api key, the key is only returned in
+// this response
+func (s *Server) CreateAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateAPIKeyRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		// An api key can't create a key with scopes it doesn't have itself
+		for _, scope := range req.Scopes {
+			if err := checkScope(r, scope); err != nil {
+				render.Render(w, r, ErrFrom(err))
+				return
+			}
+		}
+
+		selectedCustomer, err := s.accountCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		apiKey, key, err := customer.NewAPIKey(selectedCustomer.ID, req.Name, req.Scopes)
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		apiKey.CreatedBy = apiKeyCreator(r)
+		if err := s.APIKeyRepository.Create(r.Context(), apiKey); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, &CreateAPIKeyResponse{APIKey: apiKey, Key: key})
+	}
+}
+
+// apiKeyCreator returns the member creating an api key, keys created by a key of a member are
+// the member's too. It returns nil when the account's own customer creates the key.
+func apiKeyCreator(r *http.Request) *uint64 {
+	if member, ok := r.Context().Value(memberContextKey).(*customer.Member); ok {
+		return &member.CustomerID
+	}
+	if apiKey, ok := r.Context().Value(apiKeyContextKey).(*customer.APIKey); ok {
+		return apiKey.CreatedBy
+	}
+	return nil
+}
+
+// ListAPIKeysHandler handles request for listing the customer's api keys
+func (s *Server) ListAPIKeysHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		selectedCustomer, err := s.accountCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		apiKeys, err := s.APIKeyRepository.FindByCustomerID(r.Context(), selectedCustomer.ID)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.JSON(w, r, apiKeys)
+	}
+}
+
+// RevokeAPIKeyHandler handles request for revoking one of the customer's api keys
+func (s *Server) RevokeAPIKeyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keyID, err := strconv.ParseUint(chi.URLParam(r, "keyID"), 10, 64)
+		if err != nil {
+			render.Render(w, r, ErrBadRequest(fmt.Errorf("invalid api key id")))
+			return
+		}
+
+		selectedCustomer, err := s.accountCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		if err := s.APIKeyRepository.Revoke(r.Context(), selectedCustomer.ID, keyID); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CreateAPIKeyRequest is a struct for create api key endpoint's request body
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes" validate:"required,dive,scope"`
+}
+
+// CreateAPIKeyResponse is a struct for create api key endpoint's response body
+type CreateAPIKeyResponse struct {
+	*customer.APIKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,172 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ngavinsir/notification-service/customer"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestServer_APIKeys(t *testing.T) {
+	server := setupMockServer()
+	router := server.Router()
+
+	if err := mustRegisterVerified(server, "example@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginResponse, err := mustLogin(server.LoginHandler(), "example@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, url, body string, authorization string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	var created CreateAPIKeyResponse
+	t.Run("Create with session", func(t *testing.T) {
+		rr := request("POST", "/api_keys", `{"name": "backend", "scopes": ["endpoints:write", "api_keys:read"]}`, "", loginResponse.Cookies())
+		if got, want := rr.Code, http.StatusCreated; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(created.Key, customer.APIKeyPrefix) || !strings.HasPrefix(created.Key, created.Prefix) {
+			t.Errorf("Want key starting with %s, got %s", created.Prefix, created.Key)
+		}
+	})
+
+	t.Run("Authenticate with api key", func(t *testing.T) {
+		rr := request("POST", "/callback_url", `{"callback_url": "http://www.example.com"}`, "Bearer "+created.Key, nil)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("List without plain key", func(t *testing.T) {
+		rr := request("GET", "/api_keys", "", "Bearer "+created.Key, nil)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if strings.Contains(rr.Body.String(), created.Key) {
+			t.Error("Listed api keys contain the plain key")
+		}
+
+		var apiKeys []*customer.APIKey
+		if err := json.NewDecoder(rr.Body).Decode(&apiKeys); err != nil {
+			t.Fatal(err)
+		}
+		if len(apiKeys) != 1 || apiKeys[0].LastUsedAt == nil {
+			t.Errorf("Want 1 used api key, got %+v", apiKeys)
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		rr := request("DELETE", "/api_keys/1", "", "", loginResponse.Cookies())
+		if got, want := rr.Code, http.StatusNoContent; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		rr = request("GET", "/api_keys", "", "Bearer "+created.Key, nil)
+		if got, want := rr.Code, http.StatusUnauthorized; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestServer_APIKeyScopes(t *testing.T) {
+	server := setupMockServer()
+	router := server.Router()
+
+	if err := mustRegisterVerified(server, "example@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	selectedCustomer, err := server.CustomerRepository.FindByEmail(context.Background(), "example@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey, key, err := customer.NewAPIKey(selectedCustomer.ID, "monitoring", []string{customer.ScopeEventsRead, customer.ScopeAPIKeysWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.APIKeyRepository.Create(context.Background(), apiKey); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		name             string
+		method           string
+		url              string
+		body             string
+		wantMissingScope string
+	}{
+		{"Set callback url", "POST", "/callback_url", `{"callback_url": "http://www.example.com"}`, customer.ScopeEndpointsWrite},
+		{"Replay events", "POST", "/events/replay", `{"payment_ids": ["123"]}`, customer.ScopeEventsReplay},
+		{"List api keys", "GET", "/api_keys", "", customer.ScopeAPIKeysRead},
+		{"List deliveries", "GET", "/deliveries", "", customer.ScopeDeliveriesRead},
+		{"Update profile", "PATCH", "/me", `{"company_name": "Example"}`, customer.ScopeProfileWrite},
+		{"Create api key with more scopes", "POST", "/api_keys", `{"name": "admin", "scopes": ["events:replay"]}`, customer.ScopeEventsReplay},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := request(test.method, test.url, test.body)
+			if got, want := rr.Code, http.StatusForbidden; got != want {
+				t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+			}
+
+			var errResponse ErrResponse
+			if err := json.NewDecoder(rr.Body).Decode(&errResponse); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := errResponse.MissingScope, test.wantMissingScope; got != want {
+				t.Errorf("Want missing scope %s, got %s", want, got)
+			}
+		})
+	}
+
+	t.Run("Create api key with own scopes", func(t *testing.T) {
+		rr := request("POST", "/api_keys", `{"name": "reader", "scopes": ["events:read"]}`)
+		if got, want := rr.Code, http.StatusCreated; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Resend verification email", func(t *testing.T) {
+		rr := request("POST", "/verify-email/resend", "")
+		if got, want := rr.Code, http.StatusForbidden; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Unknown scope", func(t *testing.T) {
+		rr := request("POST", "/api_keys", `{"name": "reader", "scopes": ["everything"]}`)
+		if got, want := rr.Code, http.StatusBadRequest; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/ngavinsir/notification-service/customer"
+	"github.com/streadway/amqp"
+)
+
+// PaymentEventType is the type of broker message published for a payment callback
+const PaymentEventType = "payment.paid"
+
+// BrokerMessage is the normalized event published to message broker
+type BrokerMessage struct {
+	Type       string      `json:"type"`
+	CustomerID uint64      `json:"customer_id"`
+	SentAt     time.Time   `json:"sent_at"`
+	Replay     bool        `json:"replay"`
+	Data       interface{} `json:"data"`
+}
+
+// NewBrokerMessage wraps notification body into normalized broker message
+func NewBrokerMessage(ctx context.Context, customer *customer.Customer, body interface{}) *BrokerMessage {
+	return &BrokerMessage{
+		Type:       PaymentEventType,
+		CustomerID: customer.ID,
+		SentAt:     time.Now(),
+		Replay:     IsReplay(ctx),
+		Data:       body,
+	}
+}
+
+// AMQPChannel publishes notification to an AMQP topic exchange and waits for the
+// broker's publisher confirm, messages without a queue bound to their routing key are
+// returned by the broker and fail the send
+type AMQPChannel struct {
+	URL      string
+	Exchange string
+
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+// NewAMQPChannel returns new amqp channel, the connection is opened on first send
+func NewAMQPChannel(url, exchange string) *AMQPChannel {
+	return &AMQPChannel{
+		URL:      url,
+		Exchange: exchange,
+	}
+}
+
+// Send publishes the body with customer's routing key, defaults to payment.<customer id>
+func (c *AMQPChannel) Send(ctx context.Context, customer *customer.Customer, body interface{}) error {
+	routingKey, err := brokerNameOf(customer, "routing_key")
+	if err != nil {
+		return err
+	}
+	message, err := json.Marshal(NewBrokerMessage(ctx, customer, body))
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.connect(); err != nil {
+		return err
+	}
+
+	err = c.channel.Publish(c.Exchange, routingKey, true, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Type:         PaymentEventType,
+		Body:         message,
+	})
+	if err != nil {
+		c.close()
+		return err
+	}
+
+	select {
+	case confirm, ok := <-c.confirms:
+		if !ok {
+			c.close()
+			return fmt.Errorf("amqp channel is closed before publish is confirmed")
+		}
+		if !confirm.Ack {
+			return fmt.Errorf("amqp broker rejected the message")
+		}
+		// The broker returns an unroutable message before confirming it
+		select {
+		case returned := <-c.returns:
+			return fmt.Errorf("amqp broker returned the message with routing key %s: %s", returned.RoutingKey, returned.ReplyText)
+		default:
+		}
+		return nil
+	case <-ctx.Done():
+		// Pending confirm would be read by next publish, so start over with a new connection
+		c.close()
+		return ctx.Err()
+	}
+}
+
+// ValidateConfig checks that config's routing key, if set, is under the customer's prefix
+func (c *AMQPChannel) ValidateConfig(customer *customer.Customer, config customer.ChannelConfig) error {
+	routingKey, ok := config["routing_key"]
+	if !ok {
+		return nil
+	}
+	if strings.ContainsAny(routingKey, " \t\r\n*#") {
+		return fmt.Errorf("routing_key must be a valid routing key without wildcards")
+	}
+	return checkBrokerName("routing_key", customer, routingKey)
+}
+
+func (c *AMQPChannel) connect() error {
+	if c.conn != nil && !c.conn.IsClosed() {
+		return nil
+	}
+	c.close()
+
+	conn, err := amqp.Dial(c.URL)
+	if err != nil {
+		return err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if err := channel.ExchangeDeclare(c.Exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		conn.Close()
+		return err
+	}
+	if err := channel.Confirm(false); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c.conn = conn
+	c.channel = channel
+	c.confirms = channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	c.returns = channel.NotifyReturn(make(chan amqp.Return, 1))
+	return nil
+}
+
+func (c *AMQPChannel) close() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = nil
+	c.channel = nil
+	c.confirms = nil
+	c.returns = nil
+}
+
+// NATSChannel publishes notification to a NATS subject and flushes the connection so
+// the server has received it before the send is reported successful
+type NATSChannel struct {
+	URL string
+
+	mu   sync.Mutex
+	conn *nats.Conn
+}
+
+// NewNATSChannel returns new nats channel, the connection is opened on first send
+func NewNATSChannel(url string) *NATSChannel {
+	return &NATSChannel{
+		URL: url,
+	}
+}
+
+// Send publishes the body to customer's subject, defaults to payment.<customer id>
+func (c *NATSChannel) Send(ctx context.Context, customer *customer.Customer, body interface{}) error {
+	subject, err := brokerNameOf(customer, "subject")
+	if err != nil {
+		return err
+	}
+	message, err := json.Marshal(NewBrokerMessage(ctx, customer, body))
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+
+	if err := conn.Publish(subject, message); err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); ok {
+		return conn.FlushWithContext(ctx)
+	}
+	return conn.FlushTimeout(5 * time.Second)
+}
+
+// ValidateConfig checks that config's subject, if set, is a valid publish subject under the
+// customer's prefix
+func (c *NATSChannel) ValidateConfig(customer *customer.Customer, config customer.ChannelConfig) error {
+	subject, ok := config["subject"]
+	if !ok {
+		return nil
+	}
+	if strings.ContainsAny(subject, " \t\r\n*>") {
+		return fmt.Errorf("subject must be a valid nats subject without wildcards")
+	}
+	return checkBrokerName("subject", customer, subject)
+}
+
+func (c *NATSChannel) connect() (*nats.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil && !c.conn.IsClosed() {
+		return c.conn, nil
+	}
+
+	conn, err := nats.Connect(c.URL, nats.Name("notification-service"))
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// brokerNameOf returns the routing key or subject of the customer from its config field,
+// names stored before they had to be under the customer's prefix are rejected
+func brokerNameOf(customer *customer.Customer, field string) (string, error) {
+	if customer.Callback == nil || customer.Callback.Config[field] == "" {
+		return brokerPrefix(customer), nil
+	}
+
+	name := customer.Callback.Config[field]
+	if err := checkBrokerName(field, customer, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// checkBrokerName returns error unless name is the customer's prefix or a dot separated name
+// under it, so a customer can't publish to the consumers of another customer
+func checkBrokerName(field string, customer *customer.Customer, name string) error {
+	prefix := brokerPrefix(customer)
+	if name != prefix && !strings.HasPrefix(name, prefix+".") {
+		return fmt.Errorf("%s must be %s or start with %s.", field, prefix, prefix)
+	}
+	if strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
+		return fmt.Errorf("%s must not have empty words", field)
+	}
+	return nil
+}
+
+// brokerPrefix returns the default routing key and subject of the customer, custom ones
+// have to start with it
+func brokerPrefix(customer *customer.Customer) string {
+	return fmt.Sprintf("payment.%d", customer.ID)
+}
@@ -0,0 +1,170 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/ngavinsir/notification-service/customer"
+	. "github.com/ngavinsir/notification-service/server"
+	"github.com/streadway/amqp"
+)
+
+// The nats test runs against an embedded nats server, the amqp test against a rabbitmq
+// container, e.g. the test target of docker-compose.test.yml:
+//   docker-compose -f docker-compose.test.yml run --rm test
+// CI has to set AMQP_URL, the amqp test fails there without it rather than skipping.
+
+func brokerTestCustomer(channel string, config customer.ChannelConfig) *customer.Customer {
+	selectedCustomer := customer.New("example@example.com", "")
+	selectedCustomer.ID = 1
+	selectedCustomer.Callback = customer.NewCallback("", 1)
+	selectedCustomer.Callback.Channel = channel
+	selectedCustomer.Callback.Config = config
+	return selectedCustomer
+}
+
+func TestAMQPChannel_Send(t *testing.T) {
+	amqpURL := os.Getenv("AMQP_URL")
+	if amqpURL == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("AMQP_URL is required in CI, run the tests with docker-compose.test.yml")
+		}
+		t.Skip("AMQP_URL is not set, run the tests with docker-compose.test.yml")
+	}
+
+	exchange := "notifications_test"
+	channel := NewAMQPChannel(amqpURL, exchange)
+	selectedCustomer := brokerTestCustomer(customer.ChannelAMQP, customer.ChannelConfig{"routing_key": "payment.1.merchant.one"})
+
+	// Declare the exchange and bind a queue before publishing
+	if err := channel.Send(context.Background(), selectedCustomer, "warm up"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	consumer, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := consumer.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.QueueBind(queue.Name, "payment.1.merchant.one", exchange, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := consumer.Consume(queue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := channel.Send(context.Background(), selectedCustomer, map[string]string{"payment_id": "123"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case delivery := <-deliveries:
+		assertBrokerMessage(t, delivery.Body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("amqp message isn't received")
+	}
+
+	unbound := brokerTestCustomer(customer.ChannelAMQP, customer.ChannelConfig{"routing_key": "payment.1.unbound"})
+	if err := channel.Send(context.Background(), unbound, map[string]string{"payment_id": "123"}); err == nil {
+		t.Error("Want error when no queue is bound to the routing key")
+	}
+}
+
+func TestNATSChannel_Send(t *testing.T) {
+	options := natstest.DefaultTestOptions
+	options.Port = -1
+	natsServer := natstest.RunServer(&options)
+	defer natsServer.Shutdown()
+	natsURL := natsServer.ClientURL()
+
+	conn, err := nats.Connect(natsURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	subscription, err := conn.SubscribeSync("payment.1.merchant.one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	channel := NewNATSChannel(natsURL)
+	selectedCustomer := brokerTestCustomer(customer.ChannelNATS, customer.ChannelConfig{"subject": "payment.1.merchant.one"})
+	if err := channel.Send(context.Background(), selectedCustomer, map[string]string{"payment_id": "123"}); err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := subscription.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBrokerMessage(t, message.Data)
+}
+
+func TestBrokerChannel_ValidateConfig(t *testing.T) {
+	selectedCustomer := brokerTestCustomer(customer.ChannelAMQP, nil)
+
+	tests := []struct {
+		channel Channel
+		config  customer.ChannelConfig
+		isValid bool
+	}{
+		{NewAMQPChannel("", ""), customer.ChannelConfig{}, true},
+		{NewAMQPChannel("", ""), customer.ChannelConfig{"routing_key": "payment.1"}, true},
+		{NewAMQPChannel("", ""), customer.ChannelConfig{"routing_key": "payment.1.merchant.one"}, true},
+		{NewAMQPChannel("", ""), customer.ChannelConfig{"routing_key": ""}, false},
+		{NewAMQPChannel("", ""), customer.ChannelConfig{"routing_key": "payment.2"}, false},
+		{NewAMQPChannel("", ""), customer.ChannelConfig{"routing_key": "payment.12"}, false},
+		{NewAMQPChannel("", ""), customer.ChannelConfig{"routing_key": "payment.1.#"}, false},
+		{NewNATSChannel(""), customer.ChannelConfig{"subject": "payment.1.merchant.one"}, true},
+		{NewNATSChannel(""), customer.ChannelConfig{"subject": "merchant.one"}, false},
+		{NewNATSChannel(""), customer.ChannelConfig{"subject": "payment.1."}, false},
+		{NewNATSChannel(""), customer.ChannelConfig{"subject": "payment.1.>"}, false},
+	}
+
+	for _, test := range tests {
+		err := test.channel.ValidateConfig(selectedCustomer, test.config)
+		if got, want := err == nil, test.isValid; got != want {
+			t.Errorf("Want config %v valid to be %v, got error %v", test.config, want, err)
+		}
+	}
+}
+
+func assertBrokerMessage(t *testing.T, body []byte) {
+	t.Helper()
+
+	var message struct {
+		Type       string            `json:"type"`
+		CustomerID uint64            `json:"customer_id"`
+		Data       map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := message.Type, PaymentEventType; got != want {
+		t.Errorf("Want message type %s, got %s", want, got)
+	}
+	if got, want := message.CustomerID, uint64(1); got != want {
+		t.Errorf("Want customer id %d, got %d", want, got)
+	}
+	if got, want := message.Data["payment_id"], "123"; got != want {
+		t.Errorf("Want payment id %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/ngavinsir/notification-service/customer"
+)
+
+// Channel is a medium that a notification can be delivered through, e.g. HTTP webhook or email
+type Channel interface {
+	// Send delivers the body to customer according to customer's callback config
+	Send(ctx context.Context, customer *customer.Customer, body interface{}) error
+	// ValidateConfig checks the customer's callback config before it is stored
+	ValidateConfig(customer *customer.Customer, config customer.ChannelConfig) error
+}
+
+// ChannelRegistry holds every channel available for delivering notification, keyed by channel name
+type ChannelRegistry struct {
+	mu       sync.RWMutex
+	channels map[string]Channel
+}
+
+// NewChannelRegistry returns new empty channel registry
+func NewChannelRegistry() *ChannelRegistry {
+	return &ChannelRegistry{
+		channels: make(map[string]Channel),
+	}
+}
+
+// NewChannelRegistryFromEnv returns channel registry with every channel configured by environment variables,
+// sse notifications are fanned out through the redis pool to every instance
+func NewChannelRegistryFromEnv(pool *redis.Pool) *ChannelRegistry {
+	registry := NewChannelRegistry()
+	registry.Register(customer.ChannelHTTP, NewWebhookChannel(&http.Client{}))
+	registry.Register(customer.ChannelSSE, NewRedisSSEChannel(pool))
+
+	if smtpConfig := NewSMTPConfigFromEnv(); smtpConfig.Host != "" {
+		smtpChannel, err := NewSMTPChannel(smtpConfig)
+		if err != nil {
+			log.Printf("email channel is disabled, error: %v", err)
+		} else {
+			registry.Register(customer.ChannelEmail, smtpChannel)
+		}
+	}
+
+	if amqpURL := os.Getenv("AMQP_URL"); amqpURL != "" {
+		exchange := os.Getenv("AMQP_EXCHANGE")
+		if exchange == "" {
+			exchange = "notifications"
+		}
+		registry.Register(customer.ChannelAMQP, NewAMQPChannel(amqpURL, exchange))
+	}
+	if natsURL := os.Getenv("NATS_URL"); natsURL != "" {
+		registry.Register(customer.ChannelNATS, NewNATSChannel(natsURL))
+	}
+
+	return registry
+}
+
+// Register adds channel to the registry, replacing channel registered with the same name
+func (r *ChannelRegistry) Register(name string, channel Channel) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.channels[name] = channel
+}
+
+// Get returns channel registered with the given name
+func (r *ChannelRegistry) Get(name string) (Channel, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	channel, ok := r.channels[name]
+	return channel, ok
+}
+
+// Names returns sorted names of registered channels
+func (r *ChannelRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.channels))
+	for name := range r.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReplayHeader is set on webhook requests that replay an event delivered before
+const ReplayHeader = "X-Notification-Replay"
+
+// WebhookChannel delivers notification by firing POST HTTP request to customer's callback url
+type WebhookChannel struct {
+	HTTPClient *http.Client
+}
+
+// NewWebhookChannel returns new webhook channel
+func NewWebhookChannel(httpClient *http.Client) *WebhookChannel {
+	return &WebhookChannel{
+		HTTPClient: httpClient,
+	}
+}
+
+// Send posts the body as json to customer's callback url, config's url takes precedence if set
+func (c *WebhookChannel) Send(ctx context.Context, customer *customer.Customer, body interface{}) error {
+	if customer.Callback == nil {
+		return fmt.Errorf("customer doesn't have callback url")
+	}
+	callbackURL := customer.Callback.Config["url"]
+	if callbackURL == "" {
+		callbackURL = customer.Callback.CallbackURL
+	}
+	if callbackURL == "" {
+		return fmt.Errorf("customer doesn't have callback url")
+	}
+
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		callbackURL,
+		bytes.NewBuffer(reqBody),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if IsReplay(ctx) {
+		req.Header.Set(ReplayHeader, "true")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback url responded with status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// ValidateConfig checks that config's url, if set, is an absolute http url
+func (c *WebhookChannel) ValidateConfig(_ *customer.Customer, config customer.ChannelConfig) error {
+	rawURL, ok := config["url"]
+	if !ok {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http url")
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ngavinsir/notification-service/customer"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+type MockChannel struct {
+	sent     chan customer.ChannelConfig
+	failures int
+}
+
+func (m *MockChannel) Send(_ context.Context, customer *customer.Customer, _ interface{}) error {
+	if m.failures > 0 {
+		m.failures--
+		return fmt.Errorf("mock failure")
+	}
+	m.sent <- customer.Callback.Config
+	return nil
+}
+
+func (m *MockChannel) ValidateConfig(_ *customer.Customer, _ customer.ChannelConfig) error {
+	return nil
+}
+
+func TestNotifier_PicksChannelAtDeliveryTime(t *testing.T) {
+	mockChannel := &MockChannel{sent: make(chan customer.ChannelConfig, 1)}
+	channels := NewChannelRegistry()
+	channels.Register("mock", mockChannel)
+
+	selectedCustomer := customer.New("example@example.com", "")
+	selectedCustomer.Callback = customer.NewCallback("", 0)
+	selectedCustomer.Callback.Channel = "mock"
+	selectedCustomer.Callback.Config = customer.ChannelConfig{"key": "value"}
+
+	NewNotifier(channels, &MockDeliveryRepository{}).Notify(context.Background(), selectedCustomer, "payload")
+
+	select {
+	case config := <-mockChannel.sent:
+		if got, want := config["key"], "value"; got != want {
+			t.Errorf("Want channel config %s, got %s", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification isn't sent through the customer's channel")
+	}
+}
+
+type MockDeadLetterQueue struct {
+	bodies []interface{}
+}
+
+func (m *MockDeadLetterQueue) Put(_ context.Context, _ uint64, _ string, body interface{}, _ error) {
+	m.bodies = append(m.bodies, body)
+}
+
+func TestNotifier_Retry(t *testing.T) {
+	selectedCustomer := customer.New("example@example.com", "")
+	selectedCustomer.Callback = customer.NewCallback("", 0)
+	selectedCustomer.Callback.Channel = "mock"
+
+	setupNotifier := func(failures int) (*NotifierImplementation, *MockChannel, *MockDeadLetterQueue) {
+		mockChannel := &MockChannel{sent: make(chan customer.ChannelConfig, 1), failures: failures}
+		deadLetters := &MockDeadLetterQueue{}
+		channels := NewChannelRegistry()
+		channels.Register("mock", mockChannel)
+
+		notifier := NewNotifier(channels, &MockDeliveryRepository{})
+		notifier.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+		notifier.DeadLetters = deadLetters
+		return notifier, mockChannel, deadLetters
+	}
+
+	t.Run("Delivered after retry", func(t *testing.T) {
+		notifier, mockChannel, deadLetters := setupNotifier(2)
+		notifier.Notify(context.Background(), selectedCustomer, "payload")
+
+		if got, want := len(mockChannel.sent), 1; got != want {
+			t.Errorf("Want %d delivered notification, got %d", want, got)
+		}
+		if got, want := len(deadLetters.bodies), 0; got != want {
+			t.Errorf("Want %d dead letter, got %d", want, got)
+		}
+	})
+
+	t.Run("Dead-lettered after every attempt fails", func(t *testing.T) {
+		notifier, mockChannel, deadLetters := setupNotifier(3)
+		notifier.Notify(context.Background(), selectedCustomer, "payload")
+
+		if got, want := len(mockChannel.sent), 0; got != want {
+			t.Errorf("Want %d delivered notification, got %d", want, got)
+		}
+		if got, want := len(deadLetters.bodies), 1; got != want {
+			t.Errorf("Want %d dead letter, got %d", want, got)
+		}
+	})
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+		if got := policy.Backoff(attempt); got != want {
+			t.Errorf("Want backoff %v for attempt %d, got %v", want, attempt, got)
+		}
+	}
+}
+
+func TestWebhookChannel_ValidateConfig(t *testing.T) {
+	channel := NewWebhookChannel(&http.Client{})
+
+	tests := []struct {
+		config  customer.ChannelConfig
+		isValid bool
+	}{
+		{customer.ChannelConfig{}, true},
+		{customer.ChannelConfig{"url": "https://example.com/callback"}, true},
+		{customer.ChannelConfig{"url": "example.com/callback"}, false},
+		{customer.ChannelConfig{"url": "ftp://example.com"}, false},
+	}
+
+	for _, test := range tests {
+		err := channel.ValidateConfig(customer.New("example@example.com", ""), test.config)
+		if got, want := err == nil, test.isValid; got != want {
+			t.Errorf("Want config %v valid to be %v, got error %v", test.config, want, err)
+		}
+	}
+}
+
+func TestSSEChannel_ServeStream(t *testing.T) {
+	channel := NewSSEChannel()
+	selectedCustomer := customer.New("example@example.com", "")
+	selectedCustomer.ID = 1
+
+	t.Run("Fails without open stream", func(t *testing.T) {
+		if err := channel.Send(context.Background(), selectedCustomer, "payload"); err == nil {
+			t.Error("Want error when customer has no open stream")
+		}
+	})
+
+	streamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		channel.ServeStream(w, r, selectedCustomer.ID)
+	}))
+	defer streamServer.Close()
+
+	resp, err := http.Get(streamServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if err := channel.Send(context.Background(), selectedCustomer, map[string]string{"payment_id": "123"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+
+	if got, want := strings.Join(lines, "\n"), "event: notification\ndata: {\"payment_id\":\"123\"}"; got != want {
+		t.Errorf("Want event %q, got %q", want, got)
+	}
+}
+
+func TestSSEChannel_Redis(t *testing.T) {
+	pool := newMockRedisPool(t)
+	serveInstance := NewRedisSSEChannel(pool)
+	workerInstance := NewRedisSSEChannel(pool)
+	defer serveInstance.Close()
+	defer workerInstance.Close()
+	selectedCustomer := customer.New("example@example.com", "")
+	selectedCustomer.ID = 1
+
+	t.Run("Fails without open stream", func(t *testing.T) {
+		if err := workerInstance.Send(context.Background(), selectedCustomer, "payload"); err == nil {
+			t.Error("Want error when customer has no open stream")
+		}
+	})
+
+	t.Run("Reaches stream on another instance", func(t *testing.T) {
+		events, unsubscribe := serveInstance.Subscribe(selectedCustomer.ID)
+		defer unsubscribe()
+
+		// The subscription reaches redis asynchronously
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			err := workerInstance.Send(context.Background(), selectedCustomer, map[string]string{"payment_id": "123"})
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		select {
+		case data := <-events:
+			if got, want := string(data), `{"payment_id":"123"}`; got != want {
+				t.Errorf("Want event %s, got %s", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Want event on the stream of another instance")
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/abraithwaite/jeff"
+	"github.com/go-chi/render"
+	"github.com/ngavinsir/notification-service/customer"
+)
+
+// errCredentialsByAPIKey is returned when an api key tries to change the customer's
+// credentials, only the customer itself can
+var errCredentialsByAPIKey = errors.New("api keys can't change credentials")
+
+// ChangePasswordHandler handles request for changing the customer's password, every other
+// session and login of the customer is revoked
+func (s *Server) ChangePasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ChangePasswordRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		selectedCustomer, err := s.credentialsOwner(r, req.CurrentPassword)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := s.checkPasswordPolicy("new_password", req.NewPassword, selectedCustomer.Email); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		hashedPassword, err := s.Passwords.Hash(req.NewPassword)
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		selectedCustomer.Password = hashedPassword
+		if err := s.CustomerRepository.Save(r.Context(), selectedCustomer); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		if err := s.renewLogin(w, r, selectedCustomer, selectedCustomer.Email, passedTwoFactor(r)); err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ChangeEmailHandler handles request for changing the customer's email, the new email has to
+// be verified again and the old one is told about the change
+func (s *Server) ChangeEmailHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ChangeEmailRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		selectedCustomer, err := s.credentialsOwner(r, req.CurrentPassword)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if req.NewEmail == selectedCustomer.Email {
+			render.Render(w, r, ErrBadRequest(&ValidationError{Fields: []FieldError{{
+				Field:   "new_email",
+				Message: "must be different from the current email",
+			}}}))
+			return
+		}
+
+		previousEmail := selectedCustomer.Email
+		selectedCustomer.Email = req.NewEmail
+		selectedCustomer.EmailVerifiedAt = nil
+		if err := s.CustomerRepository.Save(r.Context(), selectedCustomer); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		if err := s.renewLogin(w, r, selectedCustomer, previousEmail, passedTwoFactor(r)); err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+
+		if err := s.sendVerificationEmail(r.Context(), selectedCustomer); err != nil {
+			log.Printf("error when sends verification email to customer %d, error: %v", selectedCustomer.ID, err)
+		}
+		if err := s.Mailer.Send(r.Context(), emailChangedMail(previousEmail, selectedCustomer)); err != nil {
+			log.Printf("error when notifies previous email of customer %d, error: %v", selectedCustomer.ID, err)
+		}
+
+		render.JSON(w, r, selectedCustomer)
+	}
+}
+
+// credentialsOwner returns the customer logged in by the request when currentPassword is its
+// password, wrong passwords count as failed logins so a hijacked login can't guess it
+func (s *Server) credentialsOwner(r *http.Request, currentPassword string) (*customer.Customer, error) {
+	selectedCustomer, err := s.loggedInCustomer(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkLogin(r, selectedCustomer.Email); err != nil {
+		return nil, err
+	}
+	if !s.checkPassword(r.Context(), selectedCustomer, currentPassword) {
+		s.failLogin(r, selectedCustomer.Email, selectedCustomer)
+		return nil, &ValidationError{Fields: []FieldError{{
+			Field:   "current_password",
+			Message: "is wrong",
+		}}}
+	}
+	return selectedCustomer, nil
+}
+
+// checkPassword reports whether the password is the customer's, a hash of a legacy hasher or
+// of outdated parameters is replaced by a hash of the current hasher
+func (s *Server) checkPassword(ctx context.Context, c *customer.Customer, password string) bool {
+	// Sub-accounts have no password until one is set by a password reset
+	if c.Password == "" {
+		return false
+	}
+	ok, rehash, err := s.Passwords.Verify(password, c.Password)
+	if err != nil {
+		log.Printf("error when verifies password of customer %d, error: %v", c.ID, err)
+		return false
+	}
+	if !ok || !rehash {
+		return ok
+	}
+
+	// The login doesn't fail when the rehash does, the old hash still works
+	hashedPassword, err := s.Passwords.Hash(password)
+	if err == nil {
+		c.Password = hashedPassword
+		err = s.CustomerRepository.Save(ctx, c)
+	}
+	if err != nil {
+		log.Printf("error when rehashes password of customer %d, error: %v", c.ID, err)
+	}
+	return true
+}
+
+// checkPasswordPolicy returns ValidationError on the field when the new password of the
+// account with the email breaks the password policy
+func (s *Server) checkPasswordPolicy(field, newPassword, email string) error {
+	reasons, err := s.PasswordPolicy.Check(newPassword, email)
+	if err != nil {
+		return err
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	validationError := &ValidationError{}
+	for _, reason := range reasons {
+		validationError.Fields = append(validationError.Fields, FieldError{
+			Field:   field,
+			Message: reason,
+		})
+	}
+	return validationError
+}
+
+// loggedInCustomer returns the customer authenticated by session or access token, api keys
+// can't change credentials
+func (s *Server) loggedInCustomer(r *http.Request) (*customer.Customer, error) {
+	if r.Context().Value(apiKeyContextKey) != nil {
+		return nil, errCredentialsByAPIKey
+	}
+	return s.authenticatedCustomer(r)
+}
+
+// renewLogin revokes every session kept under previousEmail and every token of the customer
+// except the request's own login, a request authenticated by session gets a new session
+// under the customer's current email since sessions are keyed by email. twoFactor tells
+// whether the new session passed the second factor.
+func (s *Server) renewLogin(w http.ResponseWriter, r *http.Request, c *customer.Customer, previousEmail string, twoFactor bool) error {
+	keepFamily := ""
+	if claims, ok := r.Context().Value(accessClaimsContextKey).(*AccessClaims); ok {
+		keepFamily = claims.Family
+	}
+	if err := s.revokeLogins(r.Context(), c, []byte(previousEmail), keepFamily); err != nil {
+		return err
+	}
+
+	if len(jeff.ActiveSession(r.Context()).Token) == 0 {
+		return nil
+	}
+	meta, err := newSessionMeta(r, twoFactor)
+	if err != nil {
+		return err
+	}
+	return s.Jeff.Set(r.Context(), w, []byte(c.Email), meta)
+}
+
+// emailChangedMail returns email telling the previous email of the customer about the change
+func emailChangedMail(previousEmail string, c *customer.Customer) *Mail {
+	return &Mail{
+		To:      previousEmail,
+		Subject: "Your email was changed",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nThe email of your account was changed to %s and every other login was logged out. Contact us right away if you didn't change it.\n",
+			previousEmail, c.Email,
+		),
+	}
+}
+
+// ChangePasswordRequest is a struct for change password endpoint's request body, the new
+// password has to follow the password policy
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+// ChangeEmailRequest is a struct for change email endpoint's request body
+type ChangeEmailRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewEmail        string `json:"new_email" validate:"required,email,max=255"`
+}
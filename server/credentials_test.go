@@ -0,0 +1,134 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ngavinsir/notification-service/customer"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestServer_ChangeCredentials(t *testing.T) {
+	server := setupTokenServer(t)
+	router := server.Router()
+	mailer := server.Mailer.(*MockMailer)
+
+	if err := mustRegisterVerified(server, "other@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, url, body, authorization string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	startSession := func(email, password string) []*http.Cookie {
+		loginResponse, err := mustLogin(server.LoginHandler(), email, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loginResponse.Cookies()
+	}
+
+	current := startSession("example@example.com", "password")
+	other := startSession("example@example.com", "password")
+	rr := request("POST", "/login", `{"email": "example@example.com", "password": "password", "tokens": true}`, "", nil)
+	var tokens TokenPair
+	if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Wrong current password", func(t *testing.T) {
+		rr := request("POST", "/password/change", `{"current_password": "wrong", "new_password": "new password"}`, "", current)
+		if got, want := rr.Code, http.StatusBadRequest; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Change password", func(t *testing.T) {
+		rr := request("POST", "/password/change", `{"current_password": "password", "new_password": "new password"}`, "", current)
+		if got, want := rr.Code, http.StatusNoContent; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		renewed := rr.Result().Cookies()
+		if got, want := request("GET", "/sessions", "", "", renewed).Code, http.StatusOK; got != want {
+			t.Errorf("renewed session isn't logged in: got %v, want %v", got, want)
+		}
+		for _, revoked := range [][]*http.Cookie{current, other} {
+			if got := request("GET", "/sessions", "", "", revoked).Code; got == http.StatusOK {
+				t.Errorf("previous session isn't revoked: got %v", got)
+			}
+		}
+		if got, want := request("GET", "/sessions", "", "Bearer "+tokens.AccessToken, nil).Code, http.StatusUnauthorized; got != want {
+			t.Errorf("access token isn't revoked: got %v, want %v", got, want)
+		}
+		current = renewed
+		startSession("example@example.com", "new password")
+	})
+
+	t.Run("API key can't change credentials", func(t *testing.T) {
+		rr := request("POST", "/api_keys", `{"name": "backend", "scopes": ["api_keys:read"]}`, "", current)
+		var created CreateAPIKeyResponse
+		if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+
+		rr = request("POST", "/password/change", `{"current_password": "new password", "new_password": "password"}`, "Bearer "+created.Key, nil)
+		if got, want := rr.Code, http.StatusForbidden; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Email already registered", func(t *testing.T) {
+		rr := request("POST", "/email/change", `{"current_password": "new password", "new_email": "other@example.com"}`, "", current)
+		if got, want := rr.Code, http.StatusConflict; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Change email", func(t *testing.T) {
+		sent := len(mailer.Mails())
+		rr := request("POST", "/email/change", `{"current_password": "new password", "new_email": "changed@example.com"}`, "", current)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		var changed customer.Customer
+		if err := json.NewDecoder(rr.Body).Decode(&changed); err != nil {
+			t.Fatal(err)
+		}
+		if changed.Email != "changed@example.com" || changed.EmailVerified() {
+			t.Errorf("Want unverified changed@example.com, got %+v", changed)
+		}
+
+		mails := mailer.Mails()[sent:]
+		if len(mails) != 2 || mails[0].To != "changed@example.com" || mails[1].To != "example@example.com" {
+			t.Errorf("Want verification email to the new email and notification to the old one, got %+v", mails)
+		}
+
+		renewed := rr.Result().Cookies()
+		if got, want := request("GET", "/sessions", "", "", renewed).Code, http.StatusOK; got != want {
+			t.Errorf("renewed session isn't logged in: got %v, want %v", got, want)
+		}
+		if got := request("GET", "/sessions", "", "", current).Code; got == http.StatusOK {
+			t.Errorf("session of the old email isn't revoked: got %v", got)
+		}
+		if got, want := request("POST", "/callback_url", `{"callback_url": "http://www.example.com"}`, "", renewed).Code, http.StatusForbidden; got != want {
+			t.Errorf("unverified email can set callback: got %v, want %v", got, want)
+		}
+		if response, err := login(server.LoginHandler(), "example@example.com", "new password"); err != nil || response.StatusCode == http.StatusOK {
+			t.Errorf("old email can still log in")
+		}
+	})
+}
@@ -1,32 +1,137 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/render"
+	"github.com/ngavinsir/notification-service/datastore"
 )
 
-// ErrResponse contains err, http_status_code, status_text, app_code, error_text
+// Stable error codes of error responses, clients switch on these instead of the error text.
+// See docs/errors.md for the catalogue.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeValidationFailed = "validation_failed"
+	CodeRequestTooLarge  = "request_too_large"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal_error"
+)
+
+// RateLimitError is returned when the client has to wait RetryAfter before trying again
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("too many requests, retry after %s", e.RetryAfter)
+}
+
+// MissingScopeError is returned when the api key, or the role of the organization member
+// when Role is set, doesn't have the scope required by the route
+type MissingScopeError struct {
+	Scope string
+	Role  string
+}
+
+func (e *MissingScopeError) Error() string {
+	if e.Role != "" {
+		return fmt.Sprintf("role %s is missing scope: %s", e.Role, e.Scope)
+	}
+	return fmt.Sprintf("api key is missing scope: %s", e.Scope)
+}
+
+// ErrResponse contains err, http_status_code, status_text, code, error_text, the missing
+// scope and the invalid fields of the request
 type ErrResponse struct {
-	Err            error `json:"-"`
-	HTTPStatusCode int   `json:"-"`
+	Err            error         `json:"-"`
+	HTTPStatusCode int           `json:"-"`
+	RetryAfter     time.Duration `json:"-"`
 
-	StatusText string `json:"status"`
-	ErrorText  string `json:"error,omitempty"`
+	StatusText   string       `json:"status"`
+	Code         string       `json:"code"`
+	ErrorText    string       `json:"error,omitempty"`
+	MissingScope string       `json:"missing_scope,omitempty"`
+	Details      []FieldError `json:"details,omitempty"`
 }
 
 // Render error response
 func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((e.RetryAfter+time.Second-1)/time.Second)))
+	}
 	render.Status(r, e.HTTPStatusCode)
 	return nil
 }
 
-// ErrBadRequest returns bad request error response
+// ErrFrom returns error response matching the kind of err, errors of unknown kind are
+// internal errors
+func ErrFrom(err error) render.Renderer {
+	var validationError *ValidationError
+	var rateLimitError *RateLimitError
+	var missingScopeError *MissingScopeError
+	switch {
+	case errors.As(err, &validationError):
+		return ErrBadRequest(err)
+	case err == errRequestTooLarge:
+		return ErrRequestTooLarge(err)
+	case errors.Is(err, errInvalidToken), errors.Is(err, errRefreshTokenReused), errors.Is(err, errInvalidTwoFactorCode):
+		return ErrUnauthorized(err)
+	case errors.As(err, &missingScopeError), errors.Is(err, errEmailNotVerified), errors.Is(err, errCredentialsByAPIKey),
+		errors.Is(err, errTwoFactorRequired), errors.Is(err, errOrganizationByAPIKey), errors.Is(err, errOwnerRequired),
+		errors.Is(err, errInvitationEmail):
+		return ErrForbidden(err)
+	case errors.As(err, &rateLimitError):
+		return ErrTooManyRequests(err, rateLimitError.RetryAfter)
+	case errors.Is(err, datastore.ErrNotFound):
+		return ErrNotFound(err)
+	case errors.Is(err, datastore.ErrConflict):
+		return ErrConflict(err)
+	}
+	return ErrInternalServer(err)
+}
+
+// ErrBadRequest returns bad request error response, with the invalid fields when err is
+// a validation error
 func ErrBadRequest(err error) render.Renderer {
-	return &ErrResponse{
+	errResponse := &ErrResponse{
 		Err:            err,
 		HTTPStatusCode: http.StatusBadRequest,
 		StatusText:     "bad request",
+		Code:           CodeBadRequest,
+		ErrorText:      err.Error(),
+	}
+
+	var validationError *ValidationError
+	if errors.As(err, &validationError) {
+		errResponse.Code = CodeValidationFailed
+		errResponse.Details = validationError.Fields
+	}
+	return errResponse
+}
+
+// ErrInvalidRequest returns error response for request body that can't be decoded
+func ErrInvalidRequest(err error) render.Renderer {
+	if err == errRequestTooLarge {
+		return ErrRequestTooLarge(err)
+	}
+	return ErrBadRequest(err)
+}
+
+// ErrRequestTooLarge returns request entity too large error response
+func ErrRequestTooLarge(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusRequestEntityTooLarge,
+		StatusText:     "request too large",
+		Code:           CodeRequestTooLarge,
 		ErrorText:      err.Error(),
 	}
 }
@@ -37,6 +142,7 @@ func ErrInternalServer(err error) render.Renderer {
 		Err:            err,
 		HTTPStatusCode: http.StatusInternalServerError,
 		StatusText:     "internal error",
+		Code:           CodeInternal,
 		ErrorText:      err.Error(),
 	}
 }
@@ -47,6 +153,60 @@ func ErrUnauthorized(err error) render.Renderer {
 		Err:            err,
 		HTTPStatusCode: http.StatusUnauthorized,
 		StatusText:     "Unauthorized",
+		Code:           CodeUnauthorized,
+		ErrorText:      err.Error(),
+	}
+}
+
+// ErrForbidden returns forbidden error response, naming the missing scope when err is a
+// missing scope error
+func ErrForbidden(err error) render.Renderer {
+	errResponse := &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusForbidden,
+		StatusText:     "forbidden",
+		Code:           CodeForbidden,
+		ErrorText:      err.Error(),
+	}
+
+	var missingScopeError *MissingScopeError
+	if errors.As(err, &missingScopeError) {
+		errResponse.MissingScope = missingScopeError.Scope
+	}
+	return errResponse
+}
+
+// ErrNotFound returns not found error response
+func ErrNotFound(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusNotFound,
+		StatusText:     "not found",
+		Code:           CodeNotFound,
+		ErrorText:      err.Error(),
+	}
+}
+
+// ErrConflict returns conflict error response
+func ErrConflict(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusConflict,
+		StatusText:     "conflict",
+		Code:           CodeConflict,
+		ErrorText:      err.Error(),
+	}
+}
+
+// ErrTooManyRequests returns too many requests error response telling the client when to
+// retry
+func ErrTooManyRequests(err error, retryAfter time.Duration) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusTooManyRequests,
+		RetryAfter:     retryAfter,
+		StatusText:     "too many requests",
+		Code:           CodeRateLimited,
 		ErrorText:      err.Error(),
 	}
 }
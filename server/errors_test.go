@@ -0,0 +1,48 @@
+package server_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/ngavinsir/notification-service/datastore"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestErrFrom(t *testing.T) {
+	tests := []struct {
+		err            error
+		wantStatusCode int
+		wantCode       string
+	}{
+		{datastore.NewError(datastore.ErrNotFound, nil, "can't find event"), http.StatusNotFound, CodeNotFound},
+		{datastore.NewError(datastore.ErrConflict, nil, "event is not orphaned"), http.StatusConflict, CodeConflict},
+		{datastore.NewError(datastore.ErrDatabase, fmt.Errorf("connection refused"), "database error"), http.StatusInternalServerError, CodeInternal},
+		{&ValidationError{Fields: []FieldError{{Field: "email", Message: "is required"}}}, http.StatusBadRequest, CodeValidationFailed},
+		{&RateLimitError{RetryAfter: 1500 * time.Millisecond}, http.StatusTooManyRequests, CodeRateLimited},
+	}
+
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		render.Render(rr, httptest.NewRequest("GET", "/", nil), ErrFrom(test.err))
+
+		if got, want := rr.Code, test.wantStatusCode; got != want {
+			t.Errorf("Want status code %d for %v, got %d", want, test.err, got)
+		}
+		errResponse := ErrFrom(test.err).(*ErrResponse)
+		if got, want := errResponse.Code, test.wantCode; got != want {
+			t.Errorf("Want code %s for %v, got %s", want, test.err, got)
+		}
+	}
+
+	t.Run("Retry-After header", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		render.Render(rr, httptest.NewRequest("GET", "/", nil), ErrFrom(&RateLimitError{RetryAfter: 1500 * time.Millisecond}))
+		if got, want := rr.Header().Get("Retry-After"), "2"; got != want {
+			t.Errorf("Want Retry-After %s, got %s", want, got)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ngavinsir/notification-service/event"
+)
+
+// redactedHeaders are not archived with inbound events since they carry credentials
+var redactedHeaders = []string{"Authorization", "Cookie"}
+
+// newInboundEvent reads the whole request body and returns event carrying the raw request
+func newInboundEvent(r *http.Request, provider, eventType string) (*event.Event, error) {
+	rawBody, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(event.Headers, len(r.Header))
+	for key, values := range r.Header {
+		headers[key] = append([]string(nil), values...)
+	}
+	for _, key := range redactedHeaders {
+		if _, ok := headers[key]; ok {
+			headers[key] = []string{"[redacted]"}
+		}
+	}
+
+	inboundEvent := event.New(provider, eventType, 0, nil)
+	inboundEvent.RawBody = rawBody
+	inboundEvent.Headers = headers
+	inboundEvent.SourceIP = remoteIP(r)
+	return inboundEvent, nil
+}
+
+// remoteIP returns ip address of the request's client
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// Policies for events of unknown customer, the event is stored as orphaned either way
+const (
+	// OrphanPolicyReject responds 404 to the provider
+	OrphanPolicyReject = "reject"
+	// OrphanPolicyPark accepts the event with 202 and parks it until it is assigned
+	OrphanPolicyPark = "park"
+)
+
+// archiveEvent stores the inbound event that isn't delivered with the reason
+func (s *Server) archiveEvent(ctx context.Context, inboundEvent *event.Event, status string, reason error) error {
+	inboundEvent.Status = status
+	inboundEvent.Error = reason.Error()
+	if err := s.EventRepository.Archive(ctx, inboundEvent); err != nil {
+		log.Printf("error when archives %s event, error: %v", inboundEvent.Provider, err)
+		return err
+	}
+	return nil
+}
+
+// RunRetention deletes events older than retention every hour until ctx is done
+func (s *Server) RunRetention(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := s.EventRepository.DeleteReceivedBefore(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("error when deletes expired events, error: %v", err)
+		} else if deleted > 0 {
+			log.Printf("deleted %d events older than %s", deleted, retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// PaymentEvent is an inbound payment callback passed from ingestion to delivery workers
+type PaymentEvent struct {
+	EventID    uint64          `json:"event_id"`
+	CustomerID uint64          `json:"customer_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Replay     bool            `json:"replay"`
+}
+
+// EventBusChannel is the channel of dead letters of events the delivery workers failed to
+// handle, before any notification was sent
+const EventBusChannel = "event_bus"
+
+// EventHandler processes an event consumed from the event bus, the event is acknowledged
+// only when the handler returns nil
+type EventHandler func(ctx context.Context, event *PaymentEvent) error
+
+// EventBus passes inbound payment events from ingestion to delivery workers
+type EventBus interface {
+	Publish(ctx context.Context, event *PaymentEvent) error
+	// Consume blocks and passes every event received by the named consumer to handler
+	// until ctx is done
+	Consume(ctx context.Context, consumer string, handler EventHandler) error
+}
+
+// InProcessEventBus is an event bus that passes events between goroutines of the same process
+type InProcessEventBus struct {
+	events chan *PaymentEvent
+}
+
+// NewInProcessEventBus returns new in-process event bus
+func NewInProcessEventBus() *InProcessEventBus {
+	return &InProcessEventBus{
+		events: make(chan *PaymentEvent, 100),
+	}
+}
+
+// Publish queues the event for consumers
+func (b *InProcessEventBus) Publish(ctx context.Context, event *PaymentEvent) error {
+	select {
+	case b.events <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Consume passes queued events to handler until ctx is done, failed events are dropped
+func (b *InProcessEventBus) Consume(ctx context.Context, consumer string, handler EventHandler) error {
+	for {
+		select {
+		case event := <-b.events:
+			if err := handler(ctx, event); err != nil {
+				log.Printf("consumer %s failed to handle event of customer %d, error: %v", consumer, event.CustomerID, err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// RedisStreamBus is an event bus backed by a redis stream, events are shared between
+// consumers of the same consumer group and events left pending by crashed consumers are
+// reclaimed after ClaimIdle. Events that fail MaxDeliveries times are put to DeadLetters.
+type RedisStreamBus struct {
+	Pool          *redis.Pool
+	Stream        string
+	Group         string
+	MaxLen        int
+	BatchSize     int
+	Block         time.Duration
+	ClaimIdle     time.Duration
+	MaxDeliveries int
+	DeadLetters   DeadLetterQueue
+}
+
+// NewRedisStreamBus returns new redis stream event bus
+func NewRedisStreamBus(pool *redis.Pool, stream, group string) *RedisStreamBus {
+	return &RedisStreamBus{
+		Pool:          pool,
+		Stream:        stream,
+		Group:         group,
+		MaxLen:        100000,
+		BatchSize:     10,
+		Block:         5 * time.Second,
+		ClaimIdle:     time.Minute,
+		MaxDeliveries: 5,
+		DeadLetters:   LogDeadLetterQueue{},
+	}
+}
+
+// NewRedisStreamBusFromEnv returns new redis stream event bus configured by environment variables
+func NewRedisStreamBusFromEnv(pool *redis.Pool) *RedisStreamBus {
+	bus := NewRedisStreamBus(pool, getEnv("EVENT_STREAM", "payment_events"), getEnv("EVENT_STREAM_GROUP", "notifiers"))
+	if claimIdle, err := time.ParseDuration(os.Getenv("EVENT_CLAIM_IDLE")); err == nil {
+		bus.ClaimIdle = claimIdle
+	}
+	if maxDeliveries, err := strconv.Atoi(os.Getenv("EVENT_MAX_DELIVERIES")); err == nil && maxDeliveries > 0 {
+		bus.MaxDeliveries = maxDeliveries
+	}
+	return bus
+}
+
+// Publish appends the event to the stream
+func (b *RedisStreamBus) Publish(ctx context.Context, event *PaymentEvent) error {
+	conn := b.Pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do(
+		"XADD", b.Stream, "MAXLEN", "~", b.MaxLen, "*",
+		"event_id", event.EventID,
+		"customer_id", event.CustomerID,
+		"payload", []byte(event.Payload),
+		"replay", event.Replay,
+	)
+	return err
+}
+
+// Consume reads events as the named consumer of the group, reclaiming events that are
+// pending longer than ClaimIdle before reading new ones. Failed events stay pending to be
+// reclaimed until they are dead-lettered.
+func (b *RedisStreamBus) Consume(ctx context.Context, consumer string, handler EventHandler) error {
+	if err := b.createGroup(); err != nil {
+		return err
+	}
+
+	claimCursor := "0-0"
+	for ctx.Err() == nil {
+		entries, nextCursor, err := b.claim(consumer, claimCursor)
+		if err != nil {
+			return err
+		}
+		claimCursor = nextCursor
+
+		if len(entries) == 0 {
+			entries, err = b.read(consumer)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, entry := range entries {
+			if entry.event == nil {
+				log.Printf("consumer %s drops malformed event %s", consumer, entry.id)
+			} else if err := handler(ctx, entry.event); err != nil {
+				log.Printf("consumer %s failed to handle event %s, error: %v", consumer, entry.id, err)
+				deadLettered, err := b.deadLetter(ctx, entry, err)
+				if err != nil {
+					return err
+				}
+				if !deadLettered {
+					continue
+				}
+			}
+			if err := b.ack(entry.id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+// streamEntry is an event read from the stream, event is nil when the entry is malformed
+type streamEntry struct {
+	id    string
+	event *PaymentEvent
+}
+
+func (b *RedisStreamBus) createGroup() error {
+	conn := b.Pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("XGROUP", "CREATE", b.Stream, b.Group, "0", "MKSTREAM")
+	if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+		return err
+	}
+	return nil
+}
+
+func (b *RedisStreamBus) claim(consumer, cursor string) ([]streamEntry, string, error) {
+	conn := b.Pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Values(conn.Do(
+		"XAUTOCLAIM", b.Stream, b.Group, consumer, b.ClaimIdle.Milliseconds(), cursor,
+		"COUNT", b.BatchSize,
+	))
+	if err != nil {
+		return nil, cursor, err
+	}
+	if len(reply) < 2 {
+		return nil, cursor, fmt.Errorf("unexpected XAUTOCLAIM reply")
+	}
+
+	nextCursor, err := redis.String(reply[0], nil)
+	if err != nil {
+		return nil, cursor, err
+	}
+	entries, err := parseStreamEntries(reply[1])
+	return entries, nextCursor, err
+}
+
+func (b *RedisStreamBus) read(consumer string) ([]streamEntry, error) {
+	conn := b.Pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Values(conn.Do(
+		"XREADGROUP", "GROUP", b.Group, consumer,
+		"COUNT", b.BatchSize, "BLOCK", b.Block.Milliseconds(),
+		"STREAMS", b.Stream, ">",
+	))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []streamEntry
+	for _, stream := range reply {
+		streamReply, err := redis.Values(stream, nil)
+		if err != nil || len(streamReply) != 2 {
+			return nil, fmt.Errorf("unexpected XREADGROUP reply")
+		}
+		streamEntries, err := parseStreamEntries(streamReply[1])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, streamEntries...)
+	}
+	return entries, nil
+}
+
+// deadLetter puts the failed event to DeadLetters once it is delivered MaxDeliveries times,
+// and reports whether it did so the entry can be acknowledged
+func (b *RedisStreamBus) deadLetter(ctx context.Context, entry streamEntry, handlerErr error) (bool, error) {
+	deliveries, err := b.deliveryCount(entry.id)
+	if err != nil {
+		return false, err
+	}
+	if deliveries < b.MaxDeliveries {
+		return false, nil
+	}
+
+	ctx = ContextWithEventID(ctx, entry.event.EventID)
+	if entry.event.Replay {
+		ctx = ContextWithReplay(ctx)
+	}
+	b.DeadLetters.Put(ctx, entry.event.CustomerID, EventBusChannel, entry.event.Payload, handlerErr)
+	return true, nil
+}
+
+// deliveryCount returns how many times the pending entry has been delivered to consumers
+func (b *RedisStreamBus) deliveryCount(id string) (int, error) {
+	conn := b.Pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Values(conn.Do("XPENDING", b.Stream, b.Group, id, id, 1))
+	if err != nil {
+		return 0, err
+	}
+	// The entry isn't pending anymore, e.g. it was acknowledged after being reclaimed
+	if len(reply) == 0 {
+		return 0, nil
+	}
+
+	pending, err := redis.Values(reply[0], nil)
+	if err != nil || len(pending) != 4 {
+		return 0, fmt.Errorf("unexpected XPENDING reply")
+	}
+	return redis.Int(pending[3], nil)
+}
+
+func (b *RedisStreamBus) ack(id string) error {
+	conn := b.Pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("XACK", b.Stream, b.Group, id)
+	return err
+}
+
+func parseStreamEntries(reply interface{}) ([]streamEntry, error) {
+	rawEntries, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]streamEntry, 0, len(rawEntries))
+	for _, rawEntry := range rawEntries {
+		// Entries deleted from the stream while pending are returned as nil
+		if rawEntry == nil {
+			continue
+		}
+		entry, err := redis.Values(rawEntry, nil)
+		if err != nil || len(entry) != 2 {
+			return nil, fmt.Errorf("unexpected stream entry")
+		}
+
+		id, err := redis.String(entry[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := redis.StringMap(entry[1], nil)
+		if err != nil {
+			entries = append(entries, streamEntry{id: id})
+			continue
+		}
+		eventID, err := strconv.ParseUint(fields["event_id"], 10, 64)
+		if err != nil {
+			entries = append(entries, streamEntry{id: id})
+			continue
+		}
+		customerID, err := strconv.ParseUint(fields["customer_id"], 10, 64)
+		if err != nil {
+			entries = append(entries, streamEntry{id: id})
+			continue
+		}
+
+		entries = append(entries, streamEntry{
+			id: id,
+			event: &PaymentEvent{
+				EventID:    eventID,
+				CustomerID: customerID,
+				Payload:    json.RawMessage(fields["payload"]),
+				Replay:     fields["replay"] == "1",
+			},
+		})
+	}
+	return entries, nil
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
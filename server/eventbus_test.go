@@ -0,0 +1,150 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func setupRedisStreamBus(t *testing.T) *RedisStreamBus {
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(redisServer.Close)
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) { return redis.Dial("tcp", redisServer.Addr()) },
+	}
+	bus := NewRedisStreamBus(pool, "payment_events", "notifiers")
+	bus.Block = 10 * time.Millisecond
+	bus.ClaimIdle = 50 * time.Millisecond
+	return bus
+}
+
+func TestRedisStreamBus_Consume(t *testing.T) {
+	bus := setupRedisStreamBus(t)
+
+	event := &PaymentEvent{CustomerID: 1, Payload: json.RawMessage(`{"payment_id":"123"}`)}
+	if err := bus.Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan *PaymentEvent, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bus.Consume(ctx, "worker-1", func(_ context.Context, event *PaymentEvent) error {
+		received <- event
+		return nil
+	})
+
+	select {
+	case got := <-received:
+		if got.CustomerID != event.CustomerID || string(got.Payload) != string(event.Payload) {
+			t.Errorf("Want event %+v, got %+v", event, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event isn't consumed")
+	}
+}
+
+func TestRedisStreamBus_ReclaimPendingEvent(t *testing.T) {
+	bus := setupRedisStreamBus(t)
+
+	if err := bus.Publish(context.Background(), &PaymentEvent{CustomerID: 1, Payload: json.RawMessage(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// First worker crashes after reading the event, leaving it pending
+	crashed := make(chan struct{})
+	crashedCtx, crash := context.WithCancel(context.Background())
+	go bus.Consume(crashedCtx, "worker-1", func(_ context.Context, _ *PaymentEvent) error {
+		crash()
+		close(crashed)
+		return fmt.Errorf("worker crashed")
+	})
+	select {
+	case <-crashed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event isn't consumed by first worker")
+	}
+
+	received := make(chan *PaymentEvent, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bus.Consume(ctx, "worker-2", func(_ context.Context, event *PaymentEvent) error {
+		received <- event
+		return nil
+	})
+
+	select {
+	case got := <-received:
+		if got.CustomerID != 1 {
+			t.Errorf("Want event of customer 1, got %d", got.CustomerID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending event isn't reclaimed by second worker")
+	}
+}
+
+type MockEventDeadLetterQueue chan interface{}
+
+func (m MockEventDeadLetterQueue) Put(_ context.Context, _ uint64, _ string, body interface{}, _ error) {
+	m <- body
+}
+
+func TestRedisStreamBus_DeadLetterPoisonEvent(t *testing.T) {
+	bus := setupRedisStreamBus(t)
+	bus.ClaimIdle = time.Millisecond
+	bus.MaxDeliveries = 3
+	deadLetters := make(MockEventDeadLetterQueue, 1)
+	bus.DeadLetters = deadLetters
+
+	payload := json.RawMessage(`{"payment_id":"123"}`)
+	if err := bus.Publish(context.Background(), &PaymentEvent{CustomerID: 1, Payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bus.Consume(ctx, "worker-1", func(_ context.Context, _ *PaymentEvent) error {
+		attempts <- struct{}{}
+		return fmt.Errorf("handler always fails")
+	})
+
+	select {
+	case body := <-deadLetters:
+		if got, ok := body.(json.RawMessage); !ok || string(got) != string(payload) {
+			t.Errorf("Want dead letter of payload %s, got %v", payload, body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("poison event isn't dead-lettered")
+	}
+	if got, want := len(attempts), bus.MaxDeliveries; got != want {
+		t.Errorf("Want %d attempts, got %d", want, got)
+	}
+
+	conn := bus.Pool.Get()
+	defer conn.Close()
+	pending := -1
+	for deadline := time.Now().Add(5 * time.Second); pending != 0 && time.Now().Before(deadline); {
+		reply, err := redis.Values(conn.Do("XPENDING", bus.Stream, bus.Group))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending, err = redis.Int(reply[0], nil); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if pending != 0 {
+		t.Errorf("Want dead-lettered event acknowledged, got %d pending", pending)
+	}
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/gomodule/redigo/redis"
+	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/datastore"
+	"github.com/ngavinsir/notification-service/util/password"
+)
+
+// errInvitationEmail is returned when an invitation is accepted by a customer with another
+// email than the invited one
+var errInvitationEmail = errors.New("the invitation was sent to another email")
+
+// Invitations issues single-use invitation tokens to join an organization, only hashes of
+// the tokens are kept in redis
+type Invitations struct {
+	Pool *redis.Pool
+	TTL  time.Duration
+	// URL is the page the invitation email links to with the token as token query parameter,
+	// the email contains only the token when URL is empty
+	URL string
+}
+
+// NewInvitations returns new invitations
+func NewInvitations(pool *redis.Pool) *Invitations {
+	return &Invitations{
+		Pool: pool,
+		TTL:  7 * 24 * time.Hour,
+	}
+}
+
+// NewInvitationsFromEnv returns new invitations configured by environment variables
+func NewInvitationsFromEnv(pool *redis.Pool) *Invitations {
+	invitations := NewInvitations(pool)
+	if ttl, err := time.ParseDuration(os.Getenv("INVITATION_TTL")); err == nil {
+		invitations.TTL = ttl
+	}
+	invitations.URL = os.Getenv("INVITATION_URL")
+	return invitations
+}
+
+// Create returns new token of the invitation
+func (i *Invitations) Create(ctx context.Context, invitation *customer.Invitation) (string, error) {
+	token, err := password.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	value, err := json.Marshal(invitation)
+	if err != nil {
+		return "", err
+	}
+
+	conn := i.Pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("SET", invitationKey(password.HashToken(token)), value, "PX", i.TTL.Milliseconds())
+	return token, err
+}
+
+// Find returns the invitation of the token without using the token
+func (i *Invitations) Find(ctx context.Context, token string) (*customer.Invitation, error) {
+	conn := i.Pool.Get()
+	defer conn.Close()
+
+	return decodeInvitation(conn.Do("GET", invitationKey(password.HashToken(token))))
+}
+
+// Use returns the invitation of the token, the token can only be used once
+func (i *Invitations) Use(ctx context.Context, token string) (*customer.Invitation, error) {
+	conn := i.Pool.Get()
+	defer conn.Close()
+
+	return decodeInvitation(getAndDelete.Do(conn, invitationKey(password.HashToken(token))))
+}
+
+// Mail returns invitation email to join the organization with the token
+func (i *Invitations) Mail(invitation *customer.Invitation, organization *customer.Customer, token string) *Mail {
+	action := "Accept the invitation with this token: " + token
+	if i.URL != "" {
+		action = "Accept the invitation by opening this link: " + i.URL + "?token=" + url.QueryEscape(token)
+	}
+	return &Mail{
+		To:      invitation.Email,
+		Subject: "You are invited to join " + organization.Email,
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nYou are invited to join the organization of %s as %s. Log in or register with this email, then:\n\n%s\n\nThe invitation expires in %s and can only be used once.\n",
+			invitation.Email, organization.Email, invitation.Role, action, i.TTL,
+		),
+	}
+}
+
+// decodeInvitation decodes the invitation stored in redis, a missing one is an invalid token
+func decodeInvitation(reply interface{}, err error) (*customer.Invitation, error) {
+	value, err := redis.Bytes(reply, err)
+	if err == redis.ErrNil {
+		return nil, errInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var invitation customer.Invitation
+	if err := json.Unmarshal(value, &invitation); err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func invitationKey(hash string) string {
+	return "invitation:" + hash
+}
+
+// InviteMemberHandler handles request for emailing an invitation to join the organization
+func (s *Server) InviteMemberHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req InviteMemberRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		if err := checkOwnerChange(r, req.Role); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		organization, err := s.accountCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := requireVerifiedEmail(organization); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		invitation := &customer.Invitation{
+			OrganizationID: organization.ID,
+			Email:          req.Email,
+			Role:           req.Role,
+		}
+		token, err := s.Invitations.Create(r.Context(), invitation)
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		if err := s.Mailer.Send(r.Context(), s.Invitations.Mail(invitation, organization, token)); err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// AcceptInvitationHandler handles request for the logged in customer joining an organization
+// with an invitation sent to its verified email
+func (s *Server) AcceptInvitationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AcceptInvitationRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		user, err := s.loggedInCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := requireVerifiedEmail(user); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		// The token is only used up by the invited customer
+		invitation, err := s.Invitations.Find(r.Context(), req.Token)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if !strings.EqualFold(invitation.Email, user.Email) {
+			render.Render(w, r, ErrFrom(errInvitationEmail))
+			return
+		}
+		if invitation.OrganizationID == user.ID {
+			render.Render(w, r, ErrConflict(fmt.Errorf("customers already own their organization")))
+			return
+		}
+		if _, err := s.MemberRepository.Find(r.Context(), invitation.OrganizationID, user.ID); !errors.Is(err, datastore.ErrNotFound) {
+			if err == nil {
+				err = datastore.NewError(datastore.ErrConflict, nil, "customer %d is already a member", user.ID)
+			}
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if _, err := s.Invitations.Use(r.Context(), req.Token); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		member := customer.NewMember(invitation.OrganizationID, user.ID, invitation.Role)
+		if err := s.MemberRepository.Create(r.Context(), member); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, member)
+	}
+}
+
+// InviteMemberRequest is a struct for invite member endpoint's request body
+type InviteMemberRequest struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+	Role  string `json:"role" validate:"required,oneof=owner admin developer viewer"`
+}
+
+// AcceptInvitationRequest is a struct for accept invitation endpoint's request body
+type AcceptInvitationRequest struct {
+	Token string `json:"token" validate:"required,max=128"`
+}
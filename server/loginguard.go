@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/ngavinsir/notification-service/customer"
+)
+
+// LoginGuard tracks failed logins per account and per ip in redis, failures within Window
+// delay the next attempt progressively and too many of them lock the account or ip out
+type LoginGuard struct {
+	Pool *redis.Pool
+	// Window is how long a failed attempt counts
+	Window time.Duration
+	// DelayAfter failures of an account or ip, each further attempt has to wait BaseDelay,
+	// doubled with every failure up to MaxDelay
+	DelayAfter int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// MaxFailures of an account lock it out for LockoutDuration
+	MaxFailures     int
+	LockoutDuration time.Duration
+	// MaxIPFailures of an ip, over every account, lock it out for LockoutDuration
+	MaxIPFailures int
+}
+
+// NewLoginGuard returns new login guard
+func NewLoginGuard(pool *redis.Pool) *LoginGuard {
+	return &LoginGuard{
+		Pool:            pool,
+		Window:          15 * time.Minute,
+		DelayAfter:      3,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+		MaxFailures:     10,
+		LockoutDuration: 15 * time.Minute,
+		MaxIPFailures:   100,
+	}
+}
+
+// NewLoginGuardFromEnv returns new login guard configured by environment variables
+func NewLoginGuardFromEnv(pool *redis.Pool) *LoginGuard {
+	guard := NewLoginGuard(pool)
+	durations := map[string]*time.Duration{
+		"LOGIN_FAILURE_WINDOW":   &guard.Window,
+		"LOGIN_BASE_DELAY":       &guard.BaseDelay,
+		"LOGIN_MAX_DELAY":        &guard.MaxDelay,
+		"LOGIN_LOCKOUT_DURATION": &guard.LockoutDuration,
+	}
+	for name, value := range durations {
+		if d, err := time.ParseDuration(os.Getenv(name)); err == nil {
+			*value = d
+		}
+	}
+	counts := map[string]*int{
+		"LOGIN_DELAY_AFTER":     &guard.DelayAfter,
+		"LOGIN_MAX_FAILURES":    &guard.MaxFailures,
+		"LOGIN_MAX_IP_FAILURES": &guard.MaxIPFailures,
+	}
+	for name, value := range counts {
+		if n, err := strconv.Atoi(os.Getenv(name)); err == nil {
+			*value = n
+		}
+	}
+	return guard
+}
+
+// Check returns RateLimitError when the account or the ip is locked out or has to wait
+// before the next attempt
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) error {
+	conn := g.Pool.Get()
+	defer conn.Close()
+
+	for _, subject := range loginSubjects(email, ip) {
+		for _, key := range []string{loginLockoutKey(subject), loginDelayKey(subject)} {
+			ttl, err := redis.Int64(conn.Do("PTTL", key))
+			if err != nil {
+				return err
+			}
+			if ttl > 0 {
+				return &RateLimitError{RetryAfter: time.Duration(ttl) * time.Millisecond}
+			}
+		}
+	}
+	return nil
+}
+
+// Fail records a failed attempt, it reports whether the attempt locked the account out
+func (g *LoginGuard) Fail(ctx context.Context, email, ip string) (bool, error) {
+	conn := g.Pool.Get()
+	defer conn.Close()
+
+	subjects := loginSubjects(email, ip)
+	accountLocked, err := g.fail(conn, subjects[0], g.MaxFailures)
+	if err != nil {
+		return false, err
+	}
+	ipLocked, err := g.fail(conn, subjects[1], g.MaxIPFailures)
+	if err != nil {
+		return false, err
+	}
+
+	if accountLocked {
+		log.Printf("audit: login lockout of account %s for %s after %d failed attempts, last from ip %s", email, g.LockoutDuration, g.MaxFailures, ip)
+	}
+	if ipLocked {
+		log.Printf("audit: login lockout of ip %s for %s after %d failed attempts", ip, g.LockoutDuration, g.MaxIPFailures)
+	}
+	return accountLocked, nil
+}
+
+// Succeed forgets the failed attempts of the account
+func (g *LoginGuard) Succeed(ctx context.Context, email string) error {
+	conn := g.Pool.Get()
+	defer conn.Close()
+
+	subject := loginSubjects(email, "")[0]
+	_, err := conn.Do("DEL", loginFailuresKey(subject), loginDelayKey(subject))
+	return err
+}
+
+// fail counts a failed attempt of the subject, it reports whether the subject got locked out
+func (g *LoginGuard) fail(conn redis.Conn, subject string, maxFailures int) (bool, error) {
+	failures, err := redis.Int(conn.Do("INCR", loginFailuresKey(subject)))
+	if err != nil {
+		return false, err
+	}
+	if failures == 1 {
+		if _, err := conn.Do("PEXPIRE", loginFailuresKey(subject), g.Window.Milliseconds()); err != nil {
+			return false, err
+		}
+	}
+
+	if failures >= maxFailures {
+		reply, err := conn.Do("SET", loginLockoutKey(subject), 1, "PX", g.LockoutDuration.Milliseconds(), "NX")
+		if err != nil {
+			return false, err
+		}
+		// The lockout ends with a clean slate
+		if _, err := conn.Do("DEL", loginFailuresKey(subject), loginDelayKey(subject)); err != nil {
+			return false, err
+		}
+		return reply != nil, nil
+	}
+
+	if failures >= g.DelayAfter {
+		delay := g.MaxDelay
+		if shift := uint(failures - g.DelayAfter); shift < 32 && g.BaseDelay<<shift < g.MaxDelay {
+			delay = g.BaseDelay << shift
+		}
+		if _, err := conn.Do("SET", loginDelayKey(subject), 1, "PX", delay.Milliseconds()); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// loginSubjects returns the subjects attempts are counted for, the account and the ip
+func loginSubjects(email, ip string) []string {
+	return []string{"account:" + strings.ToLower(email), "ip:" + ip}
+}
+
+func loginFailuresKey(subject string) string {
+	return "login_failures:" + subject
+}
+
+func loginDelayKey(subject string) string {
+	return "login_delay:" + subject
+}
+
+func loginLockoutKey(subject string) string {
+	return "login_lockout:" + subject
+}
+
+// checkLogin returns RateLimitError when the login of the account from the request's ip has
+// to wait
+func (s *Server) checkLogin(r *http.Request, email string) error {
+	if s.LoginGuard == nil {
+		return nil
+	}
+	return s.LoginGuard.Check(r.Context(), email, remoteIP(r))
+}
+
+// failLogin records a failed login of the account, the customer, nil when the account
+// doesn't exist, is told when the account gets locked out
+func (s *Server) failLogin(r *http.Request, email string, c *customer.Customer) {
+	if s.LoginGuard == nil {
+		return
+	}
+
+	locked, err := s.LoginGuard.Fail(r.Context(), email, remoteIP(r))
+	if err != nil {
+		log.Printf("error when records failed login, error: %v", err)
+		return
+	}
+	if !locked || c == nil {
+		return
+	}
+
+	if err := s.Mailer.Send(r.Context(), lockoutMail(c, remoteIP(r), s.LoginGuard.LockoutDuration)); err != nil {
+		log.Printf("error when notifies lockout of customer %d, error: %v", c.ID, err)
+	}
+}
+
+// succeedLogin forgets the failed logins of the account
+func (s *Server) succeedLogin(r *http.Request, email string) {
+	if s.LoginGuard == nil {
+		return
+	}
+	if err := s.LoginGuard.Succeed(r.Context(), email); err != nil {
+		log.Printf("error when resets failed logins, error: %v", err)
+	}
+}
+
+// lockoutMail returns email telling the customer its account is locked out
+func lockoutMail(c *customer.Customer, ip string, duration time.Duration) *Mail {
+	return &Mail{
+		To:      c.Email,
+		Subject: "Your account is temporarily locked",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nThere were too many failed login attempts to your account, the last one from %s, so logins are locked for %s. Reset your password if it wasn't you.\n",
+			c.Email, ip, duration,
+		),
+	}
+}
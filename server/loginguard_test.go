@@ -0,0 +1,145 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestServer_LoginGuard(t *testing.T) {
+	setup := func(t *testing.T) (*Server, func(email, password, ip string) *httptest.ResponseRecorder) {
+		server := setupTokenServer(t)
+		server.LoginGuard = NewLoginGuard(newMockRedisPool(t))
+		router := server.Router()
+
+		return server, func(email, password, ip string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			body := `{"email": "` + email + `", "password": "` + password + `", "tokens": true}`
+			req := httptest.NewRequest("POST", "/login", bytes.NewBufferString(body))
+			req.RemoteAddr = ip + ":1234"
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+	}
+
+	t.Run("Delay after failures", func(t *testing.T) {
+		server, login := setup(t)
+		server.LoginGuard.DelayAfter = 2
+		server.LoginGuard.BaseDelay = time.Minute
+		server.LoginGuard.MaxDelay = time.Hour
+
+		for i := 0; i < 2; i++ {
+			if got, want := login("example@example.com", "wrong", "10.0.0.1").Code, http.StatusUnauthorized; got != want {
+				t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+			}
+		}
+
+		rr := login("example@example.com", "password", "10.0.0.1")
+		if got, want := rr.Code, http.StatusTooManyRequests; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got, want := rr.Header().Get("Retry-After"), "60"; got != want {
+			t.Errorf("Want Retry-After %s, got %s", want, got)
+		}
+	})
+
+	t.Run("Lockout notifies the owner", func(t *testing.T) {
+		server, login := setup(t)
+		server.LoginGuard.DelayAfter = 100
+		server.LoginGuard.MaxFailures = 3
+		mailer := server.Mailer.(*MockMailer)
+		sent := len(mailer.Mails())
+
+		for i := 0; i < 3; i++ {
+			if got, want := login("example@example.com", "wrong", "10.0.0.1").Code, http.StatusUnauthorized; got != want {
+				t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+			}
+		}
+
+		// The lockout holds from every ip and with the right password
+		if got, want := login("example@example.com", "password", "10.0.0.2").Code, http.StatusTooManyRequests; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		mails := mailer.Mails()
+		if got, want := len(mails)-sent, 1; got != want {
+			t.Fatalf("Want %d lockout email, got %d", want, got)
+		}
+		if got, want := mails[len(mails)-1].To, "example@example.com"; got != want {
+			t.Errorf("Want lockout email to %s, got %s", want, got)
+		}
+	})
+
+	t.Run("Lockout of ip", func(t *testing.T) {
+		server, login := setup(t)
+		server.LoginGuard.DelayAfter = 100
+		server.LoginGuard.MaxIPFailures = 3
+
+		for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+			if got, want := login(email, "wrong", "10.0.0.1").Code, http.StatusUnauthorized; got != want {
+				t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+			}
+		}
+
+		if got, want := login("example@example.com", "password", "10.0.0.1").Code, http.StatusTooManyRequests; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got, want := login("example@example.com", "password", "10.0.0.2").Code, http.StatusOK; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Current password guesses are throttled", func(t *testing.T) {
+		server, login := setup(t)
+		server.LoginGuard.DelayAfter = 100
+		server.LoginGuard.MaxFailures = 3
+		router := server.Router()
+
+		var tokens TokenPair
+		if err := json.NewDecoder(login("example@example.com", "password", "10.0.0.1").Body).Decode(&tokens); err != nil {
+			t.Fatal(err)
+		}
+		changePassword := func(currentPassword string) int {
+			rr := httptest.NewRecorder()
+			body := `{"current_password": "` + currentPassword + `", "new_password": "new password"}`
+			req := httptest.NewRequest("POST", "/password/change", bytes.NewBufferString(body))
+			req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+			router.ServeHTTP(rr, req)
+			return rr.Code
+		}
+
+		for i := 0; i < 3; i++ {
+			if got, want := changePassword("wrong"), http.StatusBadRequest; got != want {
+				t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+			}
+		}
+		if got, want := changePassword("password"), http.StatusTooManyRequests; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Success resets failures", func(t *testing.T) {
+		server, login := setup(t)
+		server.LoginGuard.DelayAfter = 100
+		server.LoginGuard.MaxFailures = 3
+
+		for i := 0; i < 2; i++ {
+			login("example@example.com", "wrong", "10.0.0.1")
+		}
+		if got, want := login("example@example.com", "password", "10.0.0.1").Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		for i := 0; i < 2; i++ {
+			login("example@example.com", "wrong", "10.0.0.1")
+		}
+		if got, want := login("example@example.com", "password", "10.0.0.1").Code, http.StatusOK; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"time"
+)
+
+// Mail is an account email sent to a customer, e.g. email verification
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends account emails, unlike channels it isn't chosen by the customer
+type Mailer interface {
+	Send(ctx context.Context, mail *Mail) error
+}
+
+// NewMailerFromEnv returns smtp mailer when SMTP_HOST is set, otherwise a mailer that only
+// logs the recipients of the emails
+func NewMailerFromEnv() Mailer {
+	config := NewSMTPConfigFromEnv()
+	if config.Host == "" {
+		log.Printf("SMTP_HOST is not set, account emails aren't sent")
+		return &LogMailer{}
+	}
+	return NewSMTPMailer(config)
+}
+
+// SMTPMailer sends plain text emails through smtp server
+type SMTPMailer struct {
+	Config SMTPConfig
+}
+
+// NewSMTPMailer returns new smtp mailer
+func NewSMTPMailer(config SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{
+		Config: config,
+	}
+}
+
+// Send sends the mail through smtp server
+func (m *SMTPMailer) Send(ctx context.Context, mail *Mail) error {
+	var message bytes.Buffer
+	headers := []string{
+		"From: " + m.Config.From,
+		"To: " + mail.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", mail.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+	}
+	for _, header := range headers {
+		message.WriteString(header + "\r\n")
+	}
+	message.WriteString("\r\n")
+
+	qw := quotedprintable.NewWriter(&message)
+	if _, err := qw.Write([]byte(mail.Body)); err != nil {
+		return err
+	}
+	if err := qw.Close(); err != nil {
+		return err
+	}
+
+	return sendSMTP(ctx, m.Config, mail.To, message.Bytes())
+}
+
+// LogMailer logs emails instead of sending them, for development only. The body isn't logged
+// since it holds tokens, e.g. of email verification or password reset.
+type LogMailer struct{}
+
+// Send logs the recipient and subject of the mail
+func (m *LogMailer) Send(ctx context.Context, mail *Mail) error {
+	log.Printf("mail to %s, subject: %s", mail.To, mail.Subject)
+	return nil
+}
@@ -1,69 +1,221 @@
 package server
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"log"
-	"net/http"
+	"time"
 
 	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/datastore"
+	"github.com/ngavinsir/notification-service/event"
 )
 
-// Notifier is an abstraction of HTTP Client that will notifies callback url by firing
-// POST HTTP request
+// Notifier is an abstraction that will notifies customer through the channel declared
+// in customer's callback settings
 type Notifier interface {
 	Notify(ctx context.Context, customer *customer.Customer, body interface{})
 }
 
-// NotifierImplementation is the default implementation of Notifier
-type NotifierImplementation struct {
-	HTTPClient *http.Client
+// RetryPolicy controls how a failed delivery is retried before it is dead-lettered
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
 }
 
-var notifierImplementation Notifier
-var httpClient *http.Client = &http.Client{}
+// DefaultRetryPolicy retries delivery 5 times with exponential backoff starting from 1 second
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+}
 
-// GetNotifier creates new notifier or returns created notifier
-func GetNotifier() Notifier {
-	if notifierImplementation != nil {
-		return notifierImplementation
+// Backoff returns the wait duration before the given retry attempt, starting from 1
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
 	}
+	return backoff
+}
 
-	notifierImplementation = &NotifierImplementation{
-		HTTPClient: httpClient,
+// DeadLetterQueue receives notifications that still fail after every retry attempt
+type DeadLetterQueue interface {
+	Put(ctx context.Context, customerID uint64, channel string, body interface{}, err error)
+}
+
+// LogDeadLetterQueue is a dead letter queue that only logs the failed notification
+type LogDeadLetterQueue struct{}
+
+// Put logs the failed notification
+func (LogDeadLetterQueue) Put(_ context.Context, customerID uint64, channel string, body interface{}, err error) {
+	log.Printf("dead letter for customer %d through channel %s, body: %+v, error: %v", customerID, channel, body, err)
+}
+
+// deadLetterRecordTimeout limits recording a dead letter, it is recorded even after the
+// context of the failed notification is done
+const deadLetterRecordTimeout = 10 * time.Second
+
+// DeliveryDeadLetterQueue is a dead letter queue that records the failed notification as a
+// dead-lettered delivery of its event, so the event can be replayed
+type DeliveryDeadLetterQueue struct {
+	Deliveries datastore.DeliveryRepository
+}
+
+// NewDeliveryDeadLetterQueue returns new dead letter queue recording deliveries
+func NewDeliveryDeadLetterQueue(deliveries datastore.DeliveryRepository) *DeliveryDeadLetterQueue {
+	return &DeliveryDeadLetterQueue{
+		Deliveries: deliveries,
 	}
+}
+
+// Put logs the failed notification and records it for its event, notifications of no event
+// are only logged
+func (q *DeliveryDeadLetterQueue) Put(ctx context.Context, customerID uint64, channel string, body interface{}, err error) {
+	LogDeadLetterQueue{}.Put(ctx, customerID, channel, body, err)
+
+	eventID := EventIDFromContext(ctx)
+	if eventID == 0 {
+		return
+	}
+
+	delivery := &event.Delivery{
+		EventID:    eventID,
+		CustomerID: customerID,
+		Channel:    channel,
+		Status:     event.DeliveryDeadLettered,
+		Replay:     IsReplay(ctx),
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	recordCtx, cancel := context.WithTimeout(context.Background(), deadLetterRecordTimeout)
+	defer cancel()
+	if err := q.Deliveries.Create(recordCtx, delivery); err != nil {
+		log.Printf("error when records dead letter of event %d, error: %v", eventID, err)
+	}
+}
+
+type eventIDContextKey struct{}
+
+type replayContextKey struct{}
+
+// ContextWithEventID returns context carrying the id of the inbound event being delivered,
+// deliveries are only recorded for notifications of an event
+func ContextWithEventID(ctx context.Context, eventID uint64) context.Context {
+	return context.WithValue(ctx, eventIDContextKey{}, eventID)
+}
+
+// EventIDFromContext returns the id of the inbound event being delivered, or 0 if none
+func EventIDFromContext(ctx context.Context) uint64 {
+	eventID, _ := ctx.Value(eventIDContextKey{}).(uint64)
+	return eventID
+}
 
-	return notifierImplementation
+// ContextWithReplay returns context marking the notification being delivered as a replay of
+// an event that has been delivered before
+func ContextWithReplay(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replayContextKey{}, true)
 }
 
-// Notify notifies customer's callback url
+// IsReplay returns whether the notification being delivered is a replay
+func IsReplay(ctx context.Context) bool {
+	replay, _ := ctx.Value(replayContextKey{}).(bool)
+	return replay
+}
+
+// NotifierImplementation is the default implementation of Notifier, it picks the
+// channel from the registry at delivery time and records every delivery attempt
+type NotifierImplementation struct {
+	Channels    *ChannelRegistry
+	Deliveries  datastore.DeliveryRepository
+	Retry       RetryPolicy
+	DeadLetters DeadLetterQueue
+}
+
+// NewNotifier returns new notifier that dispatches through the given channels, dead letters
+// are recorded as deliveries
+func NewNotifier(channels *ChannelRegistry, deliveries datastore.DeliveryRepository) *NotifierImplementation {
+	return &NotifierImplementation{
+		Channels:    channels,
+		Deliveries:  deliveries,
+		Retry:       DefaultRetryPolicy,
+		DeadLetters: NewDeliveryDeadLetterQueue(deliveries),
+	}
+}
+
+// Notify notifies customer through the customer's selected channel, retrying failed
+// delivery according to the retry policy
 func (n *NotifierImplementation) Notify(
 	ctx context.Context,
 	customer *customer.Customer,
 	body interface{},
 ) {
-	reqBody, err := json.Marshal(body)
-	if err != nil {
-		log.Printf("error when notifies customer %d, error: %v", customer.ID, err)
+	channelName := channelOf(customer)
+	channel, ok := n.Channels.Get(channelName)
+	if !ok {
+		log.Printf("error when notifies customer %d, error: channel %s is not available", customer.ID, channelName)
+		return
 	}
 
-	req, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodPost,
-		customer.Callback.CallbackURL,
-		bytes.NewBuffer(reqBody),
-	)
-	if err != nil {
-		log.Printf("error when notifies customer %d, error: %v", customer.ID, err)
+	var err error
+	for attempt := 1; attempt <= n.Retry.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(n.Retry.Backoff(attempt - 1)):
+			case <-ctx.Done():
+				n.DeadLetters.Put(ctx, customer.ID, channelName, body, ctx.Err())
+				return
+			}
+		}
+
+		if err = channel.Send(ctx, customer, body); err == nil {
+			n.recordDelivery(ctx, customer, channelName, attempt, event.DeliverySucceeded, nil)
+			return
+		}
+		log.Printf("error when notifies customer %d, attempt: %d, error: %v", customer.ID, attempt, err)
+		n.recordDelivery(ctx, customer, channelName, attempt, event.DeliveryFailed, err)
 	}
 
-	resp, err := n.HTTPClient.Do(req)
-	if err != nil {
-		log.Printf("error when notifies customer %d, error: %v", customer.ID, err)
+	n.DeadLetters.Put(ctx, customer.ID, channelName, body, err)
+}
+
+func (n *NotifierImplementation) recordDelivery(
+	ctx context.Context,
+	customer *customer.Customer,
+	channel string,
+	attempt int,
+	status string,
+	deliveryErr error,
+) {
+	eventID := EventIDFromContext(ctx)
+	if eventID == 0 {
+		return
+	}
+
+	delivery := &event.Delivery{
+		EventID:    eventID,
+		CustomerID: customer.ID,
+		Channel:    channel,
+		Attempt:    attempt,
+		Status:     status,
+		Replay:     IsReplay(ctx),
 	}
+	if deliveryErr != nil {
+		delivery.Error = deliveryErr.Error()
+	}
+	if err := n.Deliveries.Create(ctx, delivery); err != nil {
+		log.Printf("error when records delivery of event %d, error: %v", eventID, err)
+	}
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		log.Printf("error when notifies customer %d, error code: %v", customer.ID, resp.StatusCode)
+func channelOf(c *customer.Customer) string {
+	if c.Callback == nil || c.Callback.Channel == "" {
+		return customer.ChannelHTTP
 	}
+	return c.Callback.Channel
 }
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/datastore"
+)
+
+// OrganizationHeader selects the organization a member acts on, requests without it act on
+// the logged in customer's own account
+const OrganizationHeader = "X-Organization-ID"
+
+var (
+	// errOrganizationByAPIKey is returned when an api key selects another organization than
+	// the one it belongs to
+	errOrganizationByAPIKey = errors.New("api keys can only act on their own organization")
+	// errOwnerRequired is returned when a member who isn't an owner makes or changes owners
+	errOwnerRequired = errors.New("only owners can make or change owners")
+)
+
+// SelectOrganization lets members act on the organization selected by OrganizationHeader
+// with the scopes of their role, the header is optional. Parents act on their sub-accounts as
+// owners and members of the parent act on them with their role in the parent.
+func (s *Server) SelectOrganization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(OrganizationHeader)
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		organizationID, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			render.Render(w, r, ErrBadRequest(fmt.Errorf("invalid %s header", OrganizationHeader)))
+			return
+		}
+
+		user, err := s.authenticatedCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		// The customer's own account needs no membership
+		if organizationID == user.ID {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		organization, err := s.CustomerRepository.FindByID(r.Context(), organizationID)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		ctx := context.WithValue(r.Context(), organizationContextKey, organization)
+		if organization.IsSubAccount() && *organization.ParentID == user.ID {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		if r.Context().Value(apiKeyContextKey) != nil {
+			render.Render(w, r, ErrFrom(errOrganizationByAPIKey))
+			return
+		}
+
+		member, err := s.MemberRepository.Find(r.Context(), organizationID, user.ID)
+		if errors.Is(err, datastore.ErrNotFound) && organization.IsSubAccount() {
+			member, err = s.MemberRepository.Find(r.Context(), *organization.ParentID, user.ID)
+		}
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		ctx = context.WithValue(ctx, memberContextKey, member)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// accountCustomer returns the customer whose account the request acts on, the organization
+// selected by a member or else the authenticated customer itself
+func (s *Server) accountCustomer(r *http.Request) (*customer.Customer, error) {
+	if organization, ok := r.Context().Value(organizationContextKey).(*customer.Customer); ok {
+		return organization, nil
+	}
+	return s.authenticatedCustomer(r)
+}
+
+// requestRole returns the role of the request on the account it acts on, customers are the
+// owners of their own account
+func requestRole(r *http.Request) string {
+	if member, ok := r.Context().Value(memberContextKey).(*customer.Member); ok {
+		return member.Role
+	}
+	return customer.RoleOwner
+}
+
+// requireActingTwoFactor runs requireTwoFactor for the customer acting on the account,
+// members and parents need their own second factor rather than the organization's
+func (s *Server) requireActingTwoFactor(r *http.Request, account *customer.Customer) error {
+	if r.Context().Value(organizationContextKey) == nil {
+		return s.requireTwoFactor(r, account)
+	}
+
+	user, err := s.authenticatedCustomer(r)
+	if err != nil {
+		return err
+	}
+	return s.requireTwoFactor(r, user)
+}
+
+// requireActingVerifiedEmail runs requireVerifiedEmail for the account, parents configure
+// their sub-accounts before those verify their email so the parent's email is checked instead
+func (s *Server) requireActingVerifiedEmail(r *http.Request, account *customer.Customer) error {
+	if r.Context().Value(organizationContextKey) == nil || !account.IsSubAccount() {
+		return requireVerifiedEmail(account)
+	}
+
+	parent, err := s.CustomerRepository.FindByID(r.Context(), *account.ParentID)
+	if err != nil {
+		return err
+	}
+	return requireVerifiedEmail(parent)
+}
+
+// ListMembersHandler handles request for listing the members of the organization
+func (s *Server) ListMembersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		organization, err := s.accountCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		members, err := s.MemberRepository.FindByOrganizationID(r.Context(), organization.ID)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		response := make([]*MemberResponse, 0, len(members))
+		for _, member := range members {
+			memberCustomer, err := s.CustomerRepository.FindByID(r.Context(), member.CustomerID)
+			if err != nil {
+				render.Render(w, r, ErrFrom(err))
+				return
+			}
+			response = append(response, &MemberResponse{Member: member, Email: memberCustomer.Email})
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// UpdateMemberHandler handles request for changing the role of a member of the organization,
+// the api keys it created with scopes the new role doesn't have are revoked
+func (s *Server) UpdateMemberHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req UpdateMemberRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		member, err := s.selectedMember(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := checkOwnerChange(r, member.Role, req.Role); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		member.Role = req.Role
+		if err := s.revokeMemberAPIKeysBeyondRole(r.Context(), member); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := s.MemberRepository.Save(r.Context(), member); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.JSON(w, r, member)
+	}
+}
+
+// RemoveMemberHandler handles request for removing a member from the organization, the
+// member loses access right away and the api keys it created are revoked
+func (s *Server) RemoveMemberHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		member, err := s.selectedMember(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := checkOwnerChange(r, member.Role); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		if err := s.revokeMemberAPIKeys(r.Context(), member.OrganizationID, member.CustomerID); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := s.MemberRepository.Delete(r.Context(), member.OrganizationID, member.CustomerID); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// revokeMemberAPIKeys revokes the api keys the member created for the organization and for
+// its sub-accounts
+func (s *Server) revokeMemberAPIKeys(ctx context.Context, organizationID, customerID uint64) error {
+	customerIDs, err := s.organizationCustomerIDs(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+	return s.APIKeyRepository.RevokeByCreator(ctx, customerIDs, customerID)
+}
+
+// revokeMemberAPIKeysBeyondRole revokes the api keys the member created for the organization
+// and for its sub-accounts with scopes its role doesn't have
+func (s *Server) revokeMemberAPIKeysBeyondRole(ctx context.Context, member *customer.Member) error {
+	customerIDs, err := s.organizationCustomerIDs(ctx, member.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	roleScopes := customer.RoleScopes[member.Role]
+	for _, customerID := range customerIDs {
+		apiKeys, err := s.APIKeyRepository.FindByCustomerID(ctx, customerID)
+		if err != nil {
+			return err
+		}
+		for _, apiKey := range apiKeys {
+			if apiKey.RevokedAt != nil || apiKey.CreatedBy == nil || *apiKey.CreatedBy != member.CustomerID {
+				continue
+			}
+			if roleScopes.HasAll(apiKey.Scopes) {
+				continue
+			}
+			if err := s.APIKeyRepository.Revoke(ctx, customerID, apiKey.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// organizationCustomerIDs returns the ids of the organization and of its sub-accounts
+func (s *Server) organizationCustomerIDs(ctx context.Context, organizationID uint64) ([]uint64, error) {
+	subAccounts, err := s.CustomerRepository.FindByParentID(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	customerIDs := []uint64{organizationID}
+	for _, subAccount := range subAccounts {
+		customerIDs = append(customerIDs, subAccount.ID)
+	}
+	return customerIDs, nil
+}
+
+// selectedMember returns the member of the organization selected by the customerID url
+// parameter
+func (s *Server) selectedMember(r *http.Request) (*customer.Member, error) {
+	customerID, err := strconv.ParseUint(chi.URLParam(r, "customerID"), 10, 64)
+	if err != nil {
+		return nil, &ValidationError{Fields: []FieldError{{
+			Field:   "customer_id",
+			Message: "is invalid",
+		}}}
+	}
+
+	organization, err := s.accountCustomer(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.MemberRepository.Find(r.Context(), organization.ID, customerID)
+}
+
+// checkOwnerChange returns errOwnerRequired when one of the roles, given or taken, is owner
+// and the request isn't by an owner
+func checkOwnerChange(r *http.Request, roles ...string) error {
+	if requestRole(r) == customer.RoleOwner {
+		return nil
+	}
+	for _, role := range roles {
+		if role == customer.RoleOwner {
+			return errOwnerRequired
+		}
+	}
+	return nil
+}
+
+// ListOrganizationsHandler handles request for listing the organizations the logged in
+// customer is a member of
+func (s *Server) ListOrganizationsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.loggedInCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		members, err := s.MemberRepository.FindByCustomerID(r.Context(), user.ID)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		response := make([]*OrganizationResponse, 0, len(members))
+		for _, member := range members {
+			organization, err := s.CustomerRepository.FindByID(r.Context(), member.OrganizationID)
+			if err != nil {
+				render.Render(w, r, ErrFrom(err))
+				return
+			}
+			response = append(response, &OrganizationResponse{Member: member, Email: organization.Email})
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// LeaveOrganizationHandler handles request for the logged in customer leaving an organization,
+// the api keys it created for the organization are revoked
+func (s *Server) LeaveOrganizationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		organizationID, err := strconv.ParseUint(chi.URLParam(r, "organizationID"), 10, 64)
+		if err != nil {
+			render.Render(w, r, ErrBadRequest(fmt.Errorf("invalid organization id")))
+			return
+		}
+
+		user, err := s.loggedInCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		if _, err := s.MemberRepository.Find(r.Context(), organizationID, user.ID); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := s.revokeMemberAPIKeys(r.Context(), organizationID, user.ID); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := s.MemberRepository.Delete(r.Context(), organizationID, user.ID); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// UpdateMemberRequest is a struct for update member endpoint's request body
+type UpdateMemberRequest struct {
+	Role string `json:"role" validate:"required,oneof=owner admin developer viewer"`
+}
+
+// MemberResponse is a struct for a member in list members endpoint's response body
+type MemberResponse struct {
+	*customer.Member
+	Email string `json:"email"`
+}
+
+// OrganizationResponse is a struct for an organization in list organizations endpoint's
+// response body, Email is the organization's
+type OrganizationResponse struct {
+	*customer.Member
+	Email string `json:"email"`
+}
@@ -0,0 +1,240 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ngavinsir/notification-service/customer"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestServer_Organization(t *testing.T) {
+	server := setupTokenServer(t)
+	router := server.Router()
+	mailer := server.Mailer.(*MockMailer)
+
+	for _, email := range []string{"member@example.com", "outsider@example.com"} {
+		if err := mustRegisterVerified(server, email, "password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	organization, err := server.CustomerRepository.FindByEmail(context.Background(), "example@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	memberCustomer, err := server.CustomerRepository.FindByEmail(context.Background(), "member@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	organizationID := strconv.FormatUint(organization.ID, 10)
+	memberURL := "/members/" + strconv.FormatUint(memberCustomer.ID, 10)
+
+	request := func(method, url, body, accessToken, organizationID string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		if organizationID != "" {
+			req.Header.Set(OrganizationHeader, organizationID)
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	accessToken := func(email string) string {
+		rr := httptest.NewRecorder()
+		body := `{"email": "` + email + `", "password": "password", "tokens": true}`
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/login", bytes.NewBufferString(body)))
+		var tokens TokenPair
+		if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil {
+			t.Fatal(err)
+		}
+		return tokens.AccessToken
+	}
+	invite := func(t *testing.T, email, role, accessToken string) string {
+		rr := request("POST", "/members/invitations", `{"email": "`+email+`", "role": "`+role+`"}`, accessToken, "")
+		if got, want := rr.Code, http.StatusAccepted; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		mails := mailer.Mails()
+		const prefix = "Accept the invitation with this token: "
+		body := mails[len(mails)-1].Body
+		start := strings.Index(body, prefix)
+		if start < 0 {
+			t.Fatalf("Want invitation token in email, got %s", body)
+		}
+		return strings.Fields(body[start+len(prefix):])[0]
+	}
+
+	owner := accessToken("example@example.com")
+	member := accessToken("member@example.com")
+	outsider := accessToken("outsider@example.com")
+
+	t.Run("Invitation of another email", func(t *testing.T) {
+		token := invite(t, "someone@example.com", customer.RoleViewer, owner)
+		rr := request("POST", "/invitations/accept", `{"token": "`+token+`"}`, member, "")
+		if got, want := rr.Code, http.StatusForbidden; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Accept invitation", func(t *testing.T) {
+		token := invite(t, "member@example.com", customer.RoleDeveloper, owner)
+		rr := request("POST", "/invitations/accept", `{"token": "`+token+`"}`, member, "")
+		if got, want := rr.Code, http.StatusCreated; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		rr = request("POST", "/invitations/accept", `{"token": "`+token+`"}`, member, "")
+		if got, want := rr.Code, http.StatusUnauthorized; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("List organizations", func(t *testing.T) {
+		rr := request("GET", "/organizations", "", member, "")
+		var organizations []*OrganizationResponse
+		if err := json.NewDecoder(rr.Body).Decode(&organizations); err != nil {
+			t.Fatal(err)
+		}
+		if len(organizations) != 1 || organizations[0].Email != "example@example.com" || organizations[0].Role != customer.RoleDeveloper {
+			t.Errorf("Want membership of example@example.com as developer, got %+v", organizations)
+		}
+	})
+
+	t.Run("Developer sets callback url of the organization", func(t *testing.T) {
+		rr := request("POST", "/callback_url", `{"callback_url": "https://example.com/team"}`, member, organizationID)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		selectedCustomer, err := server.CustomerRepository.FindByID(context.Background(), organization.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := selectedCustomer.Callback.CallbackURL, "https://example.com/team"; got != want {
+			t.Errorf("Want callback url of the organization %s, got %s", want, got)
+		}
+	})
+
+	t.Run("Developer can't manage members", func(t *testing.T) {
+		rr := request("GET", "/members", "", member, organizationID)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		rr = request("POST", "/members/invitations", `{"email": "someone@example.com", "role": "viewer"}`, member, organizationID)
+		if got, want := rr.Code, http.StatusForbidden; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		var errResponse ErrResponse
+		if err := json.NewDecoder(rr.Body).Decode(&errResponse); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := errResponse.MissingScope, customer.ScopeMembersWrite; got != want {
+			t.Errorf("Want missing scope %s, got %s", want, got)
+		}
+	})
+
+	t.Run("Viewer can't set callback url", func(t *testing.T) {
+		rr := request("PATCH", memberURL, `{"role": "viewer"}`, owner, "")
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		rr = request("POST", "/callback_url", `{"callback_url": "https://example.com/viewer"}`, member, organizationID)
+		if got, want := rr.Code, http.StatusForbidden; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		rr = request("GET", "/api_keys", "", member, organizationID)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Admin can't make owners", func(t *testing.T) {
+		rr := request("PATCH", memberURL, `{"role": "admin"}`, owner, "")
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		rr = request("POST", "/members/invitations", `{"email": "outsider@example.com", "role": "owner"}`, member, organizationID)
+		if got, want := rr.Code, http.StatusForbidden; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		rr = request("PATCH", memberURL, `{"role": "owner"}`, member, organizationID)
+		if got, want := rr.Code, http.StatusForbidden; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Downgrade revokes api keys beyond the role", func(t *testing.T) {
+		createKey := func(scopes string) string {
+			rr := request("POST", "/api_keys", `{"name": "member key", "scopes": `+scopes+`}`, member, organizationID)
+			if got, want := rr.Code, http.StatusCreated; got != want {
+				t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+			}
+			var created CreateAPIKeyResponse
+			if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+				t.Fatal(err)
+			}
+			return created.Key
+		}
+		writeKey := createKey(`["api_keys:read", "members:write"]`)
+		readKey := createKey(`["api_keys:read"]`)
+
+		rr := request("PATCH", memberURL, `{"role": "viewer"}`, owner, "")
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		if got, want := request("GET", "/api_keys", "", writeKey, "").Code, http.StatusUnauthorized; got != want {
+			t.Errorf("api key beyond the new role isn't revoked: got %v, want %v", got, want)
+		}
+		if got, want := request("GET", "/api_keys", "", readKey, "").Code, http.StatusOK; got != want {
+			t.Errorf("api key within the new role is revoked: got %v, want %v", got, want)
+		}
+
+		rr = request("PATCH", memberURL, `{"role": "admin"}`, owner, "")
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Outsider can't select the organization", func(t *testing.T) {
+		rr := request("GET", "/api_keys", "", outsider, organizationID)
+		if got, want := rr.Code, http.StatusNotFound; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Remove member", func(t *testing.T) {
+		rr := request("POST", "/api_keys", `{"name": "member key", "scopes": ["api_keys:read"]}`, member, organizationID)
+		if got, want := rr.Code, http.StatusCreated; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		var created CreateAPIKeyResponse
+		if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+
+		rr = request("DELETE", memberURL, "", owner, "")
+		if got, want := rr.Code, http.StatusNoContent; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		rr = request("GET", "/api_keys", "", member, organizationID)
+		if got, want := rr.Code, http.StatusNotFound; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		rr = request("GET", "/api_keys", "", created.Key, "")
+		if got, want := rr.Code, http.StatusUnauthorized; got != want {
+			t.Errorf("api key of removed member isn't revoked: got %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ngavinsir/notification-service/datastore"
+	"github.com/ngavinsir/notification-service/event"
+)
+
+// OutboxRelay publishes events stored in the outbox to the event bus, so an event that is
+// acknowledged to the provider is delivered even if the process crashes right after
+type OutboxRelay struct {
+	Events    datastore.EventRepository
+	Bus       EventBus
+	Interval  time.Duration
+	BatchSize int
+	wake      chan struct{}
+}
+
+// NewOutboxRelay returns new outbox relay
+func NewOutboxRelay(events datastore.EventRepository, bus EventBus) *OutboxRelay {
+	return &OutboxRelay{
+		Events:    events,
+		Bus:       bus,
+		Interval:  time.Second,
+		BatchSize: 100,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Wake makes the running relay check the outbox immediately instead of waiting for the interval
+func (r *OutboxRelay) Wake() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays the outbox every interval or when woken until ctx is done
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			published, err := r.RelayOnce(ctx)
+			if err != nil {
+				log.Printf("error when relays outbox, error: %v", err)
+			}
+			if err != nil || published < r.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// RelayOnce publishes one batch of the outbox and returns the number of published events
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	return r.Events.RelayOutbox(ctx, r.BatchSize, func(e *event.Event) error {
+		return r.Bus.Publish(ctx, &PaymentEvent{
+			EventID:    e.ID,
+			CustomerID: e.CustomerID,
+			Payload:    e.Payload,
+		})
+	})
+}
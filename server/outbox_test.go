@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ngavinsir/notification-service/event"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+type FlakyEventBus struct {
+	*InProcessEventBus
+	down bool
+}
+
+func (b *FlakyEventBus) Publish(ctx context.Context, e *PaymentEvent) error {
+	if b.down {
+		return fmt.Errorf("event bus is down")
+	}
+	return b.InProcessEventBus.Publish(ctx, e)
+}
+
+func TestOutboxRelay_RelayOnce(t *testing.T) {
+	eventRepository := &MockEventRepository{}
+	bus := &FlakyEventBus{InProcessEventBus: NewInProcessEventBus(), down: true}
+	relay := NewOutboxRelay(eventRepository, bus)
+
+	inboundEvent := event.New(event.ProviderAlfamart, event.TypePaymentPaid, 1, json.RawMessage(`{}`))
+	if err := eventRepository.Create(context.Background(), inboundEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Event stays in outbox while bus is down", func(t *testing.T) {
+		published, err := relay.RelayOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if published != 0 {
+			t.Errorf("Want no published event, got %d", published)
+		}
+	})
+
+	t.Run("Event is published once bus is up", func(t *testing.T) {
+		bus.down = false
+		published, err := relay.RelayOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if published != 1 {
+			t.Errorf("Want 1 published event, got %d", published)
+		}
+
+		published, err = relay.RelayOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if published != 0 {
+			t.Errorf("Want published event to be relayed only once, got %d", published)
+		}
+	})
+}
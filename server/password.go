@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/abraithwaite/jeff"
+	"github.com/go-chi/render"
+	"github.com/gomodule/redigo/redis"
+	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/datastore"
+	"github.com/ngavinsir/notification-service/util/password"
+)
+
+// PasswordResetter issues single-use password reset tokens, only hashes of the tokens are
+// kept in redis
+type PasswordResetter struct {
+	Pool *redis.Pool
+	TTL  time.Duration
+	// URL is the page the reset email links to with the token as token query parameter, the
+	// email contains only the token when URL is empty
+	URL string
+}
+
+// NewPasswordResetter returns new password resetter
+func NewPasswordResetter(pool *redis.Pool) *PasswordResetter {
+	return &PasswordResetter{
+		Pool: pool,
+		TTL:  time.Hour,
+	}
+}
+
+// NewPasswordResetterFromEnv returns new password resetter configured by environment
+// variables
+func NewPasswordResetterFromEnv(pool *redis.Pool) *PasswordResetter {
+	resetter := NewPasswordResetter(pool)
+	if ttl, err := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL")); err == nil {
+		resetter.TTL = ttl
+	}
+	resetter.URL = os.Getenv("PASSWORD_RESET_URL")
+	return resetter
+}
+
+// Create returns new reset token of the customer
+func (p *PasswordResetter) Create(ctx context.Context, customerID uint64) (string, error) {
+	token, err := password.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	hash := password.HashToken(token)
+
+	conn := p.Pool.Get()
+	defer conn.Close()
+
+	if err := conn.Send("MULTI"); err != nil {
+		return "", err
+	}
+	conn.Send("SET", passwordResetKey(hash), customerID, "PX", p.TTL.Milliseconds())
+	conn.Send("SADD", customerPasswordResetsKey(customerID), hash)
+	conn.Send("PEXPIRE", customerPasswordResetsKey(customerID), p.TTL.Milliseconds())
+	if _, err := conn.Do("EXEC"); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// getAndDelete deletes the key of a single-use token and returns its value, concurrent uses
+// of the same token can't both get the value
+var getAndDelete = redis.NewScript(1, `
+local value = redis.call("GET", KEYS[1])
+if not value then
+	return false
+end
+redis.call("DEL", KEYS[1])
+return value
+`)
+
+// Find returns id of the customer the reset token was issued to without using the token
+func (p *PasswordResetter) Find(ctx context.Context, token string) (uint64, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+
+	customerID, err := redis.Uint64(conn.Do("GET", passwordResetKey(password.HashToken(token))))
+	if err == redis.ErrNil {
+		return 0, errInvalidToken
+	}
+	return customerID, err
+}
+
+// Use returns id of the customer the reset token was issued to, the token can only be used
+// once
+func (p *PasswordResetter) Use(ctx context.Context, token string) (uint64, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+
+	customerID, err := redis.Uint64(getAndDelete.Do(conn, passwordResetKey(password.HashToken(token))))
+	if err == redis.ErrNil {
+		return 0, errInvalidToken
+	}
+	return customerID, err
+}
+
+// Invalidate deletes every unused reset token of the customer
+func (p *PasswordResetter) Invalidate(ctx context.Context, customerID uint64) error {
+	conn := p.Pool.Get()
+	defer conn.Close()
+
+	hashes, err := redis.Strings(conn.Do("SMEMBERS", customerPasswordResetsKey(customerID)))
+	if err != nil {
+		return err
+	}
+
+	keys := []interface{}{customerPasswordResetsKey(customerID)}
+	for _, hash := range hashes {
+		keys = append(keys, passwordResetKey(hash))
+	}
+	_, err = conn.Do("DEL", keys...)
+	return err
+}
+
+// Mail returns password reset email with the token
+func (p *PasswordResetter) Mail(c *customer.Customer, token string) *Mail {
+	action := "Reset your password with this token: " + token
+	if p.URL != "" {
+		action = "Reset your password by opening this link: " + p.URL + "?token=" + url.QueryEscape(token)
+	}
+	return &Mail{
+		To:      c.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n%s\n\nThe link expires in %s and can only be used once. Ignore this email if you didn't ask to reset your password.\n",
+			c.Email, action, p.TTL,
+		),
+	}
+}
+
+// passwordResetSendTimeout bounds sending a password reset email after the response
+const passwordResetSendTimeout = time.Minute
+
+func passwordResetKey(hash string) string {
+	return "password_reset:" + hash
+}
+
+func customerPasswordResetsKey(customerID uint64) string {
+	return "customer_password_resets:" + strconv.FormatUint(customerID, 10)
+}
+
+// ForgotPasswordHandler handles request for emailing a password reset token, it responds
+// the same and as fast whether the email is registered or not
+func (s *Server) ForgotPasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ForgotPasswordRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		// The email is looked up and sent after responding, so the response time doesn't tell
+		// whether it is registered
+		go func(email string) {
+			ctx, cancel := context.WithTimeout(context.Background(), passwordResetSendTimeout)
+			defer cancel()
+
+			if err := s.sendPasswordReset(ctx, email); err != nil {
+				log.Printf("error when sends password reset email, error: %v", err)
+			}
+		}(req.Email)
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (s *Server) sendPasswordReset(ctx context.Context, email string) error {
+	selectedCustomer, err := s.CustomerRepository.FindByEmail(ctx, email)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := s.PasswordResets.Create(ctx, selectedCustomer.ID)
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(ctx, s.PasswordResets.Mail(selectedCustomer, token))
+}
+
+// ResetPasswordHandler handles request for setting a new password with a reset token, every
+// session and token of the customer is revoked
+func (s *Server) ResetPasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ResetPasswordRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		customerID, err := s.PasswordResets.Find(r.Context(), req.Token)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		selectedCustomer, err := s.CustomerRepository.FindByID(r.Context(), customerID)
+		if errors.Is(err, datastore.ErrNotFound) {
+			render.Render(w, r, ErrUnauthorized(errInvalidToken))
+			return
+		}
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		// The token is only used up by a password that follows the policy
+		if err := s.checkPasswordPolicy("password", req.Password, selectedCustomer.Email); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if _, err := s.PasswordResets.Use(r.Context(), req.Token); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		hashedPassword, err := s.Passwords.Hash(req.Password)
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		selectedCustomer.Password = hashedPassword
+		if err := s.CustomerRepository.Save(r.Context(), selectedCustomer); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		if err := s.PasswordResets.Invalidate(r.Context(), selectedCustomer.ID); err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		if err := s.revokeLogins(r.Context(), selectedCustomer, []byte(selectedCustomer.Email), ""); err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// revokeLogins revokes every session of the customer kept under sessionKey and every token
+// of the customer except the tokens of the keepFamily login
+func (s *Server) revokeLogins(ctx context.Context, c *customer.Customer, sessionKey []byte, keepFamily string) error {
+	if _, err := s.Sessions.Revoke(ctx, sessionKey, func(jeff.Session) bool { return true }); err != nil {
+		return err
+	}
+	if s.Tokens == nil {
+		return nil
+	}
+	return s.Tokens.RevokeCustomer(ctx, c.ID, keepFamily)
+}
+
+// ForgotPasswordRequest is a struct for forgot password endpoint's request body
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// ResetPasswordRequest is a struct for reset password endpoint's request body, the password
+// has to follow the password policy
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required,max=128"`
+	Password string `json:"password" validate:"required"`
+}
@@ -0,0 +1,118 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestServer_PasswordReset(t *testing.T) {
+	server := setupTokenServer(t)
+	router := server.Router()
+	mailer := server.Mailer.(*MockMailer)
+
+	request := func(method, url, body, accessToken string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		if accessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	loginResponse, err := mustLogin(server.LoginHandler(), "example@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := request("POST", "/login", `{"email": "example@example.com", "password": "password", "tokens": true}`, "", nil)
+	var tokens TokenPair
+	if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Unknown email gets the same response", func(t *testing.T) {
+		sent := len(mailer.Mails())
+		rr := request("POST", "/password/forgot", `{"email": "unknown@example.com"}`, "", nil)
+		if got, want := rr.Code, http.StatusAccepted; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if got := len(mailer.Mails()); got != sent {
+			t.Errorf("Want no email sent, got %d", got-sent)
+		}
+	})
+
+	var token string
+	t.Run("Forgot", func(t *testing.T) {
+		sent := len(mailer.Mails())
+		rr := request("POST", "/password/forgot", `{"email": "example@example.com"}`, "", nil)
+		if got, want := rr.Code, http.StatusAccepted; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		// The email is sent after responding
+		mails := mailer.Mails()
+		for deadline := time.Now().Add(5 * time.Second); len(mails) == sent && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+			mails = mailer.Mails()
+		}
+		if len(mails) == sent {
+			t.Fatal("Want password reset email")
+		}
+		const prefix = "Reset your password with this token: "
+		body := mails[len(mails)-1].Body
+		start := strings.Index(body, prefix)
+		if start < 0 {
+			t.Fatalf("Want reset token in email, got %s", body)
+		}
+		token = strings.Fields(body[start+len(prefix):])[0]
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		rr := request("POST", "/password/reset", `{"token": "`+token+`x", "password": "new password"}`, "", nil)
+		if got, want := rr.Code, http.StatusUnauthorized; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Password breaks policy", func(t *testing.T) {
+		rr := request("POST", "/password/reset", `{"token": "`+token+`", "password": "short"}`, "", nil)
+		if got, want := rr.Code, http.StatusBadRequest; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		rr := request("POST", "/password/reset", `{"token": "`+token+`", "password": "new password"}`, "", nil)
+		if got, want := rr.Code, http.StatusNoContent; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		if _, err := mustLogin(server.LoginHandler(), "example@example.com", "new password"); err != nil {
+			t.Error(err)
+		}
+		if got := request("GET", "/sessions", "", "", loginResponse.Cookies()).Code; got == http.StatusOK {
+			t.Errorf("session isn't revoked: got %v", got)
+		}
+		if got, want := request("GET", "/api_keys", "", tokens.AccessToken, nil).Code, http.StatusUnauthorized; got != want {
+			t.Errorf("access token isn't revoked: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Token can only be used once", func(t *testing.T) {
+		rr := request("POST", "/password/reset", `{"token": "`+token+`", "password": "other password"}`, "", nil)
+		if got, want := rr.Code, http.StatusUnauthorized; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+)
+
+// GetProfileHandler handles request for the authenticated customer's profile with its
+// callback settings
+func (s *Server) GetProfileHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		selectedCustomer, err := s.authenticatedCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.JSON(w, r, selectedCustomer)
+	}
+}
+
+// UpdateProfileHandler handles request for changing the authenticated customer's profile,
+// only the fields in the request are changed
+func (s *Server) UpdateProfileHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req UpdateProfileRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		selectedCustomer, err := s.authenticatedCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		if req.CompanyName != nil {
+			selectedCustomer.CompanyName = *req.CompanyName
+		}
+		if req.ContactPhone != nil {
+			selectedCustomer.ContactPhone = *req.ContactPhone
+		}
+		if req.Timezone != nil {
+			selectedCustomer.Timezone = *req.Timezone
+		}
+		if err := s.CustomerRepository.Save(r.Context(), selectedCustomer); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.JSON(w, r, selectedCustomer)
+	}
+}
+
+// UpdateProfileRequest is a struct for update profile endpoint's request body, missing fields
+// are kept
+type UpdateProfileRequest struct {
+	CompanyName  *string `json:"company_name" validate:"omitempty,max=255"`
+	ContactPhone *string `json:"contact_phone" validate:"omitempty,e164"`
+	Timezone     *string `json:"timezone" validate:"omitempty,timezone"`
+}
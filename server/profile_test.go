@@ -0,0 +1,98 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ngavinsir/notification-service/customer"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestServer_Profile(t *testing.T) {
+	server := setupTokenServer(t)
+	router := server.Router()
+
+	loginResponse, err := mustLogin(server.LoginHandler(), "example@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		for _, cookie := range loginResponse.Cookies() {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	profile := func(t *testing.T, rr *httptest.ResponseRecorder) *customer.Customer {
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		var c customer.Customer
+		if err := json.NewDecoder(rr.Body).Decode(&c); err != nil {
+			t.Fatal(err)
+		}
+		return &c
+	}
+
+	rr := request("POST", "/callback_url", `{"callback_url": "https://example.com/callback"}`)
+	if got, want := rr.Code, http.StatusOK; got != want {
+		t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+	}
+
+	t.Run("Get profile", func(t *testing.T) {
+		c := profile(t, request("GET", "/me", ""))
+		if got, want := c.Email, "example@example.com"; got != want {
+			t.Errorf("Want email %s, got %s", want, got)
+		}
+		if c.Callback == nil || c.Callback.CallbackURL != "https://example.com/callback" {
+			t.Errorf("Want callback url https://example.com/callback, got %+v", c.Callback)
+		}
+	})
+
+	t.Run("Update profile", func(t *testing.T) {
+		body := `{"company_name": "Example Ltd", "contact_phone": "+6281234567890", "timezone": "Asia/Jakarta"}`
+		c := profile(t, request("PATCH", "/me", body))
+		if c.CompanyName != "Example Ltd" || c.ContactPhone != "+6281234567890" || c.Timezone != "Asia/Jakarta" {
+			t.Errorf("Want updated profile, got %+v", c)
+		}
+	})
+
+	t.Run("Partial update keeps other fields", func(t *testing.T) {
+		profile(t, request("PATCH", "/me", `{"company_name": "Example Inc"}`))
+
+		c := profile(t, request("GET", "/me", ""))
+		if c.CompanyName != "Example Inc" || c.ContactPhone != "+6281234567890" || c.Timezone != "Asia/Jakarta" {
+			t.Errorf("Want only company name changed, got %+v", c)
+		}
+		if c.Callback == nil || c.Callback.CallbackURL != "https://example.com/callback" {
+			t.Errorf("Want callback settings kept, got %+v", c.Callback)
+		}
+	})
+
+	t.Run("Invalid fields", func(t *testing.T) {
+		rr := request("PATCH", "/me", `{"contact_phone": "0812", "timezone": "Mars/Olympus"}`)
+		if got, want := rr.Code, http.StatusBadRequest; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		var errResponse ErrResponse
+		if err := json.NewDecoder(rr.Body).Decode(&errResponse); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(errResponse.Details), 2; got != want {
+			t.Errorf("Want %d invalid fields, got %+v", want, errResponse.Details)
+		}
+	})
+
+	t.Run("Email can't be changed", func(t *testing.T) {
+		rr := request("PATCH", "/me", `{"email": "other@example.com"}`)
+		if got, want := rr.Code, http.StatusBadRequest; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+}
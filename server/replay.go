@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/ngavinsir/notification-service/event"
+)
+
+// maxReplayEvents limits the number of events replayed by one request
+const maxReplayEvents = 1000
+
+// ReplayEventsRequest is a struct for replay events endpoint's request body, events are
+// selected by received time range, by payment ids or by their dead-lettered delivery
+type ReplayEventsRequest struct {
+	CustomerID   uint64     `json:"customer_id,omitempty"`
+	From         *time.Time `json:"from,omitempty"`
+	To           *time.Time `json:"to,omitempty"`
+	PaymentIDs   []string   `json:"payment_ids,omitempty" validate:"omitempty,max=1000,dive,required,max=64"`
+	DeadLettered bool       `json:"dead_lettered,omitempty"`
+}
+
+// ReplayEventsResponse is a struct for replay events endpoint's response body
+type ReplayEventsResponse struct {
+	Replayed int `json:"replayed"`
+}
+
+// Filter returns filter of accepted events of the customer selected by the request
+func (req *ReplayEventsRequest) Filter(customerID uint64) (*event.Filter, error) {
+	if req.From == nil && req.To == nil && len(req.PaymentIDs) == 0 && !req.DeadLettered {
+		return nil, fmt.Errorf("from/to, payment_ids or dead_lettered is required")
+	}
+
+	filter := &event.Filter{
+		CustomerID:   customerID,
+		Status:       event.StatusAccepted,
+		PaymentIDs:   req.PaymentIDs,
+		DeadLettered: req.DeadLettered,
+		Limit:        maxReplayEvents + 1,
+	}
+	if req.From != nil {
+		filter.From = *req.From
+	}
+	if req.To != nil {
+		filter.To = *req.To
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	return filter, nil
+}
+
+// ReplayEvents publishes stored events matching the filter to the event bus again, marked
+// as replay, and returns the number of replayed events
+func (s *Server) ReplayEvents(ctx context.Context, filter *event.Filter) (int, error) {
+	events, err := s.EventRepository.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	if len(events) > maxReplayEvents {
+		return 0, fmt.Errorf("can't replay more than %d events at once, narrow down the selection", maxReplayEvents)
+	}
+
+	// Find returns newest first, replay in the order the events were received
+	for i := len(events) - 1; i >= 0; i-- {
+		err := s.EventBus.Publish(ctx, &PaymentEvent{
+			EventID:    events[i].ID,
+			CustomerID: events[i].CustomerID,
+			Payload:    events[i].Payload,
+			Replay:     true,
+		})
+		if err != nil {
+			return len(events) - 1 - i, err
+		}
+	}
+
+	return len(events), nil
+}
+
+// ReplayEventsHandler handles request for replaying the logged in customer's events
+func (s *Server) ReplayEventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ReplayEventsRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		selectedCustomer, err := s.accountCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		s.replay(w, r, &req, selectedCustomer.ID)
+	}
+}
+
+// AdminReplayEventsHandler handles request for replaying events of any customer
+func (s *Server) AdminReplayEventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ReplayEventsRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		if req.CustomerID == 0 {
+			render.Render(w, r, ErrBadRequest(fmt.Errorf("customer_id is required")))
+			return
+		}
+
+		s.replay(w, r, &req, req.CustomerID)
+	}
+}
+
+func (s *Server) replay(w http.ResponseWriter, r *http.Request, req *ReplayEventsRequest, customerID uint64) {
+	filter, err := req.Filter(customerID)
+	if err != nil {
+		render.Render(w, r, ErrBadRequest(err))
+		return
+	}
+
+	replayed, err := s.ReplayEvents(r.Context(), filter)
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+
+	render.JSON(w, r, &ReplayEventsResponse{Replayed: replayed})
+}
@@ -0,0 +1,143 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ngavinsir/notification-service/event"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestServer_ReplayEvents(t *testing.T) {
+	server := setupMockServer()
+
+	replayHeaders := make(chan string, 2)
+	mockCustomerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replayHeaders <- r.Header.Get(ReplayHeader)
+		w.Write([]byte(`OK`))
+	}))
+	defer mockCustomerServer.Close()
+
+	if err := mustRegisterVerified(server, "example@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginResponse, err := mustLogin(server.LoginHandler(), "example@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mustSetCallbackURL(
+		server.Jeff.WrapFunc(server.SetCallbackURLHandler()),
+		mockCustomerServer.URL,
+		loginResponse.Cookies(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alfamartRequest := &AlfamartPaymentCallbackRequest{
+		PaymentID:   "123123123",
+		PaymentCode: "XYZ123",
+		PaidAt:      time.Now(),
+		ExternalID:  "order-123",
+		CustomerID:  1,
+	}
+	if _, err := sendRequest(server.AlfamartPaymentCallbackHandler(), "POST", "/alfamart_payment_callback", alfamartRequest, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := waitReplayHeader(t, replayHeaders); got != "" {
+		t.Errorf("Want first delivery without replay header, got %q", got)
+	}
+
+	handler := server.Jeff.WrapFunc(server.ReplayEventsHandler())
+
+	t.Run("Selection is required", func(t *testing.T) {
+		response, err := sendRequest(handler, "POST", "/events/replay", &ReplayEventsRequest{}, loginResponse.Cookies())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := response.StatusCode, http.StatusBadRequest; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Replay by payment id", func(t *testing.T) {
+		request := &ReplayEventsRequest{PaymentIDs: []string{"123123123"}}
+		response, err := sendRequest(handler, "POST", "/events/replay", request, loginResponse.Cookies())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := response.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		if got, want := waitReplayHeader(t, replayHeaders), "true"; got != want {
+			t.Errorf("Want replay header %q, got %q", want, got)
+		}
+	})
+
+	t.Run("Replay dead-lettered events", func(t *testing.T) {
+		replayDeadLettered := func(t *testing.T) int {
+			request := &ReplayEventsRequest{DeadLettered: true}
+			response, err := sendRequest(handler, "POST", "/events/replay", request, loginResponse.Cookies())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := response.StatusCode, http.StatusOK; got != want {
+				t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+			}
+			var replayResponse ReplayEventsResponse
+			if err := json.NewDecoder(response.Body).Decode(&replayResponse); err != nil {
+				t.Fatal(err)
+			}
+			return replayResponse.Replayed
+		}
+
+		waitSucceededDeliveries(t, server, 2)
+		deadLetters := server.Notifier.(*NotifierImplementation).DeadLetters
+		deadLetters.Put(ContextWithEventID(context.Background(), 1), 1, "http", nil, errors.New("customer is down"))
+
+		if got, want := replayDeadLettered(t), 1; got != want {
+			t.Fatalf("Want %d replayed event, got %d", want, got)
+		}
+		if got, want := waitReplayHeader(t, replayHeaders), "true"; got != want {
+			t.Errorf("Want replay header %q, got %q", want, got)
+		}
+
+		waitSucceededDeliveries(t, server, 3)
+		if got, want := replayDeadLettered(t), 0; got != want {
+			t.Errorf("Want no dead-lettered event after successful replay, got %d", got)
+		}
+	})
+}
+
+func waitSucceededDeliveries(t *testing.T, server *Server, want int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		deliveries, err := server.DeliveryRepository.Find(context.Background(), &event.Filter{Status: event.DeliverySucceeded})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) == want {
+			return
+		}
+	}
+	t.Fatalf("Want %d succeeded deliveries", want)
+}
+
+func waitReplayHeader(t *testing.T, replayHeaders chan string) string {
+	t.Helper()
+
+	select {
+	case header := <-replayHeaders:
+		return header
+	case <-time.After(5 * time.Second):
+		t.Fatal("customer isn't notified")
+		return ""
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/ngavinsir/notification-service/customer"
+)
+
+// RequireScope only lets requests authenticated by an api key with the scope through,
+// requests authenticated by session have every scope except members of an organization, who
+// have the scopes of their role
+func (s *Server) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := checkScope(r, scope); err != nil {
+				render.Render(w, r, ErrFrom(err))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// checkScope returns missing scope error when the request is authenticated by an api key
+// without the scope or by a member whose role doesn't have the scope
+func checkScope(r *http.Request, scope string) error {
+	apiKey, ok := r.Context().Value(apiKeyContextKey).(*customer.APIKey)
+	if ok && !apiKey.Scopes.Has(scope) {
+		return &MissingScopeError{Scope: scope}
+	}
+	member, ok := r.Context().Value(memberContextKey).(*customer.Member)
+	if ok && !customer.RoleScopes[member.Role].Has(scope) {
+		return &MissingScopeError{Scope: scope, Role: member.Role}
+	}
+	return nil
+}
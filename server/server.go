@@ -3,7 +3,9 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"time"
@@ -17,6 +19,7 @@ import (
 	"github.com/ngavinsir/notification-service/customer"
 	"github.com/ngavinsir/notification-service/datastore"
 	dssql "github.com/ngavinsir/notification-service/datastore/sql"
+	"github.com/ngavinsir/notification-service/event"
 	"github.com/ngavinsir/notification-service/util/password"
 	"gorm.io/gorm"
 )
@@ -24,7 +27,27 @@ import (
 // Server holds server's required resources
 type Server struct {
 	CustomerRepository datastore.CustomerRepository
+	APIKeyRepository   datastore.APIKeyRepository
+	MemberRepository   datastore.MemberRepository
+	DeliveryRepository datastore.DeliveryRepository
+	EventRepository    datastore.EventRepository
+	AdminAPIKey        string
+	OrphanPolicy       string
 	Jeff               *jeff.Jeff
+	Sessions           *SessionManager
+	Tokens             *TokenIssuer
+	EmailVerifier      *EmailVerifier
+	PasswordResets     *PasswordResetter
+	Invitations        *Invitations
+	TwoFactor          *TwoFactor
+	LoginGuard         *LoginGuard
+	PasswordPolicy     *password.Policy
+	Passwords          *password.Hashing
+	Mailer             Mailer
+	Channels           *ChannelRegistry
+	Notifier           Notifier
+	EventBus           EventBus
+	OutboxRelay        *OutboxRelay
 }
 
 // NewServer returns new server
@@ -35,9 +58,35 @@ func NewServer(db *gorm.DB) *Server {
 		Dial:        func() (redis.Conn, error) { return redis.Dial("tcp", os.Getenv("REDIS_URL")) },
 	}
 	sessionStore := redis_store.New(redisPool)
+	channels := NewChannelRegistryFromEnv(redisPool)
+	eventRepository := dssql.NewEventRepository(db)
+	deliveryRepository := dssql.NewDeliveryRepository(db)
+	notifier := NewNotifier(channels, deliveryRepository)
+	eventBus := NewRedisStreamBusFromEnv(redisPool)
+	eventBus.DeadLetters = notifier.DeadLetters
 
 	return &Server{
 		CustomerRepository: dssql.NewCustomerRepository(db),
+		APIKeyRepository:   dssql.NewAPIKeyRepository(db),
+		MemberRepository:   dssql.NewMemberRepository(db),
+		DeliveryRepository: deliveryRepository,
+		EventRepository:    eventRepository,
+		AdminAPIKey:        os.Getenv("ADMIN_API_KEY"),
+		OrphanPolicy:       getEnv("ORPHAN_EVENT_POLICY", OrphanPolicyReject),
+		Channels:           channels,
+		Notifier:           notifier,
+		EventBus:           eventBus,
+		OutboxRelay:        NewOutboxRelay(eventRepository, eventBus),
+		Tokens:             NewTokenIssuerFromEnv(redisPool),
+		Sessions:           NewSessionManager(sessionStore),
+		EmailVerifier:      NewEmailVerifierFromEnv(),
+		PasswordResets:     NewPasswordResetterFromEnv(redisPool),
+		Invitations:        NewInvitationsFromEnv(redisPool),
+		TwoFactor:          NewTwoFactorFromEnv(),
+		LoginGuard:         NewLoginGuardFromEnv(redisPool),
+		PasswordPolicy:     password.NewPolicyFromEnv(),
+		Passwords:          password.NewHashingFromEnv(),
+		Mailer:             NewMailerFromEnv(),
 		Jeff: jeff.New(
 			sessionStore,
 			jeff.Redirect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -56,23 +105,80 @@ func (s *Server) Router() *chi.Mux {
 
 	r.Post("/register", s.RegisterHandler())
 	r.Post("/login", s.LoginHandler())
+	r.Post("/login/2fa", s.LoginTwoFactorHandler())
+	r.Post("/token/refresh", s.RefreshTokenHandler())
+	r.Post("/token/revoke", s.RevokeTokenHandler())
+	r.Post("/verify-email", s.VerifyEmailHandler())
+	r.Post("/password/forgot", s.ForgotPasswordHandler())
+	r.Post("/password/reset", s.ResetPasswordHandler())
 	r.Post("/alfamart_payment_callback", s.AlfamartPaymentCallbackHandler())
 
-	r.Post("/callback_url", s.Jeff.WrapFunc(s.SetCallbackURLHandler()))
+	r.Group(func(r chi.Router) {
+		r.Use(s.Authenticate)
+
+		// Routes of the account, members act on the organization selected by header
+		r.Group(func(r chi.Router) {
+			r.Use(s.SelectOrganization)
+			r.With(s.RequireScope(customer.ScopeEndpointsWrite)).Post("/callback_url", s.SetCallbackURLHandler())
+			r.With(s.RequireScope(customer.ScopeEndpointsWrite)).Post("/callback_channel", s.SetCallbackChannelHandler())
+			r.With(s.RequireScope(customer.ScopeEventsRead)).Get("/events/stream", s.EventStreamHandler())
+			r.With(s.RequireScope(customer.ScopeEventsReplay)).Post("/events/replay", s.ReplayEventsHandler())
+			r.With(s.RequireScope(customer.ScopeAPIKeysWrite)).Post("/api_keys", s.CreateAPIKeyHandler())
+			r.With(s.RequireScope(customer.ScopeAPIKeysRead)).Get("/api_keys", s.ListAPIKeysHandler())
+			r.With(s.RequireScope(customer.ScopeAPIKeysWrite)).Delete("/api_keys/{keyID}", s.RevokeAPIKeyHandler())
+			r.With(s.RequireScope(customer.ScopeMembersRead)).Get("/members", s.ListMembersHandler())
+			r.With(s.RequireScope(customer.ScopeMembersWrite)).Post("/members/invitations", s.InviteMemberHandler())
+			r.With(s.RequireScope(customer.ScopeMembersWrite)).Patch("/members/{customerID}", s.UpdateMemberHandler())
+			r.With(s.RequireScope(customer.ScopeMembersWrite)).Delete("/members/{customerID}", s.RemoveMemberHandler())
+			r.With(s.RequireScope(customer.ScopeSubAccountsWrite)).Post("/sub_accounts", s.CreateSubAccountHandler())
+			r.With(s.RequireScope(customer.ScopeSubAccountsRead)).Get("/sub_accounts", s.ListSubAccountsHandler())
+			r.With(s.RequireScope(customer.ScopeSubAccountsWrite)).Patch("/sub_accounts/{customerID}", s.UpdateSubAccountHandler())
+			r.With(s.RequireScope(customer.ScopeDeliveriesRead)).Get("/deliveries", s.ListDeliveriesHandler())
+		})
+
+		r.Post("/logout", s.LogoutHandler())
+		r.Post("/verify-email/resend", s.ResendVerificationEmailHandler())
+		r.Post("/password/change", s.ChangePasswordHandler())
+		r.Post("/email/change", s.ChangeEmailHandler())
+		r.Post("/2fa/enroll", s.EnrollTwoFactorHandler())
+		r.Post("/2fa/confirm", s.ConfirmTwoFactorHandler())
+		r.Post("/2fa/disable", s.DisableTwoFactorHandler())
+		r.Post("/invitations/accept", s.AcceptInvitationHandler())
+		r.Get("/organizations", s.ListOrganizationsHandler())
+		r.Delete("/organizations/{organizationID}", s.LeaveOrganizationHandler())
+		r.With(s.RequireScope(customer.ScopeProfileRead)).Get("/me", s.GetProfileHandler())
+		r.With(s.RequireScope(customer.ScopeProfileWrite)).Patch("/me", s.UpdateProfileHandler())
+		r.With(s.RequireScope(customer.ScopeSessionsRead)).Get("/sessions", s.ListSessionsHandler())
+		r.With(s.RequireScope(customer.ScopeSessionsWrite)).Delete("/sessions", s.RevokeOtherSessionsHandler())
+		r.With(s.RequireScope(customer.ScopeSessionsWrite)).Delete("/sessions/{sessionID}", s.RevokeSessionHandler())
+	})
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(s.AdminAuth)
+		r.Get("/events", s.AdminListEventsHandler())
+		r.Get("/events/{eventID}", s.AdminGetEventHandler())
+		r.Post("/events/replay", s.AdminReplayEventsHandler())
+		r.Post("/events/{eventID}/assign", s.AdminAssignEventHandler())
+	})
 
 	return r
 }
 
-// RegisterHandler handles request for creating a customer
+// RegisterHandler handles request for creating a customer with unverified email, the
+// verification token is emailed to the customer
 func (s *Server) RegisterHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req AuthRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			render.Render(w, r, ErrBadRequest(err))
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		if err := s.checkPasswordPolicy("password", req.Password, req.Email); err != nil {
+			render.Render(w, r, ErrFrom(err))
 			return
 		}
 
-		hashedPassword, err := password.HashPassword(req.Password)
+		hashedPassword, err := s.Passwords.Hash(req.Password)
 		if err != nil {
 			render.Render(w, r, ErrInternalServer(err))
 			return
@@ -82,62 +188,109 @@ func (s *Server) RegisterHandler() http.HandlerFunc {
 		callback := customer.NewCallback("", uint(newCustomer.ID))
 		newCustomer.Callback = callback
 		if err := s.CustomerRepository.Save(r.Context(), newCustomer); err != nil {
-			render.Render(w, r, ErrInternalServer(err))
+			render.Render(w, r, ErrFrom(err))
 			return
 		}
 
+		// The customer can ask for another verification email, so registration doesn't fail
+		if err := s.sendVerificationEmail(r.Context(), newCustomer); err != nil {
+			log.Printf("error when sends verification email to customer %d, error: %v", newCustomer.ID, err)
+		}
+
 		render.JSON(w, r, newCustomer)
 	}
 }
 
-// LoginHandler handles request for login authentication
+// LoginHandler handles request for login authentication, it starts a session or issues
+// access and refresh tokens when they are requested. Customers with 2fa get a login token
+// to finish the login with the second factor instead.
 func (s *Server) LoginHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req AuthRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			render.Render(w, r, ErrBadRequest(err))
+		var req LoginRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		if err := s.checkLogin(r, req.Email); err != nil {
+			render.Render(w, r, ErrFrom(err))
 			return
 		}
 
-		customerByEmail, err := s.CustomerRepository.FindByEmail(context.Background(), req.Email)
-		if err != nil {
+		customerByEmail, err := s.CustomerRepository.FindByEmail(r.Context(), req.Email)
+		if errors.Is(err, datastore.ErrNotFound) {
+			s.failLogin(r, req.Email, nil)
 			render.Render(w, r, ErrUnauthorized(fmt.Errorf("email/password is wrong")))
 			return
 		}
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
 
-		if !password.CheckPasswordHash(req.Password, customerByEmail.Password) {
+		if !s.checkPassword(r.Context(), customerByEmail, req.Password) {
+			s.failLogin(r, req.Email, customerByEmail)
 			render.Render(w, r, ErrUnauthorized(fmt.Errorf("email/password is wrong")))
 			return
 		}
 
-		if err = s.Jeff.Set(r.Context(), w, []byte(req.Email)); err != nil {
-			render.Render(w, r, ErrInternalServer(err))
+		if customerByEmail.TwoFactorEnabled() {
+			s.challengeTwoFactor(w, r, customerByEmail, req.Tokens)
 			return
 		}
 
-		render.JSON(w, r, customerByEmail)
+		s.startLogin(w, r, customerByEmail, req.Tokens, false)
+	}
+}
+
+// startLogin starts a session of the customer or issues tokens when they are requested,
+// twoFactor tells whether the login passed the second factor
+func (s *Server) startLogin(w http.ResponseWriter, r *http.Request, c *customer.Customer, tokens bool, twoFactor bool) {
+	s.succeedLogin(r, c.Email)
+
+	if tokens {
+		s.issueTokens(w, r, c, twoFactor)
+		return
+	}
+
+	meta, err := newSessionMeta(r, twoFactor)
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+	if err := s.Jeff.Set(r.Context(), w, []byte(c.Email), meta); err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
 	}
+
+	render.JSON(w, r, c)
 }
 
 // SetCallbackURLHandler handles request for setting customer's callback url
 func (s *Server) SetCallbackURLHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req SetCallbackURLRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			render.Render(w, r, ErrBadRequest(err))
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
 			return
 		}
 
-		sess := jeff.ActiveSession(r.Context())
-		selectedCustomer, err := s.CustomerRepository.FindByEmail(r.Context(), string(sess.Key))
+		selectedCustomer, err := s.accountCustomer(r)
 		if err != nil {
-			render.Render(w, r, ErrInternalServer(err))
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := s.requireActingVerifiedEmail(r, selectedCustomer); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := s.requireActingTwoFactor(r, selectedCustomer); err != nil {
+			render.Render(w, r, ErrFrom(err))
 			return
 		}
 
 		selectedCustomer.Callback.CallbackURL = req.CallbackURL
 		if err := s.CustomerRepository.Save(r.Context(), selectedCustomer); err != nil {
-			render.Render(w, r, ErrInternalServer(err))
+			render.Render(w, r, ErrFrom(err))
 			return
 		}
 
@@ -145,41 +298,223 @@ func (s *Server) SetCallbackURLHandler() http.HandlerFunc {
 	}
 }
 
-// AlfamartPaymentCallbackHandler handles payment callback from alfamart service
+// SetCallbackChannelHandler handles request for selecting customer's notification channel
+func (s *Server) SetCallbackChannelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SetCallbackChannelRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		channel, ok := s.Channels.Get(req.Channel)
+		if !ok {
+			render.Render(w, r, ErrBadRequest(fmt.Errorf("unknown channel: %s", req.Channel)))
+			return
+		}
+
+		selectedCustomer, err := s.accountCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := channel.ValidateConfig(selectedCustomer, req.Config); err != nil {
+			render.Render(w, r, ErrBadRequest(err))
+			return
+		}
+		if err := s.requireActingVerifiedEmail(r, selectedCustomer); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if err := s.requireActingTwoFactor(r, selectedCustomer); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		selectedCustomer.Callback.Channel = req.Channel
+		selectedCustomer.Callback.Config = req.Config
+		if err := s.CustomerRepository.Save(r.Context(), selectedCustomer); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.JSON(w, r, req)
+	}
+}
+
+// EventStreamHandler streams customer's notifications as server-sent events
+func (s *Server) EventStreamHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channel, ok := s.Channels.Get(customer.ChannelSSE)
+		sseChannel, isSSE := channel.(*SSEChannel)
+		if !ok || !isSSE {
+			render.Render(w, r, ErrBadRequest(fmt.Errorf("sse channel is not available")))
+			return
+		}
+
+		selectedCustomer, err := s.accountCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		sseChannel.ServeStream(w, r, selectedCustomer.ID)
+	}
+}
+
+// AlfamartPaymentCallbackHandler handles payment callback from alfamart service, every
+// request is archived as an inbound event whether it can be delivered or not
 func (s *Server) AlfamartPaymentCallbackHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		inboundEvent, err := newInboundEvent(r, event.ProviderAlfamart, event.TypePaymentPaid)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
 		var req AlfamartPaymentCallbackRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := unmarshalRequest(inboundEvent.RawBody, &req); err != nil {
+			s.archiveEvent(r.Context(), inboundEvent, event.StatusInvalid, err)
 			render.Render(w, r, ErrBadRequest(err))
 			return
 		}
 
+		payload, err := json.Marshal(req)
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		inboundEvent.CustomerID = req.CustomerID
+		inboundEvent.Payload = payload
+
 		involvedCustomer, err := s.CustomerRepository.FindByID(r.Context(), req.CustomerID)
+		if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
 		if err != nil {
+			if err := s.archiveEvent(r.Context(), inboundEvent, event.StatusOrphaned, err); err != nil {
+				render.Render(w, r, ErrInternalServer(err))
+				return
+			}
+
+			if s.OrphanPolicy == OrphanPolicyPark {
+				render.Status(r, http.StatusAccepted)
+				render.JSON(w, r, req)
+				return
+			}
+			render.Render(w, r, ErrNotFound(fmt.Errorf("unknown customer_id: %d", req.CustomerID)))
+			return
+		}
+
+		inboundEvent.CustomerID = involvedCustomer.ID
+		if err := s.EventRepository.Create(r.Context(), inboundEvent); err != nil {
+			render.Render(w, r, ErrInternalServer(err))
 			return
 		}
+		s.OutboxRelay.Wake()
 
-		go GetNotifier().Notify(context.Background(), involvedCustomer, req)
 		render.JSON(w, r, req)
 	}
 }
 
-// AuthRequest is a struct for register and login endpoint's request body
+// RunWorkers starts n notifier workers consuming events from the event bus until ctx is done
+func (s *Server) RunWorkers(ctx context.Context, n int) {
+	hostname, _ := os.Hostname()
+
+	for i := 0; i < n; i++ {
+		consumer := fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), i)
+		go func() {
+			for ctx.Err() == nil {
+				if err := s.EventBus.Consume(ctx, consumer, s.DeliverEvent); err != nil && ctx.Err() == nil {
+					log.Printf("notifier worker %s stopped, error: %v", consumer, err)
+					time.Sleep(time.Second)
+				}
+			}
+		}()
+	}
+}
+
+// DeliverEvent notifies the customer of the event through the customer's current channel
+func (s *Server) DeliverEvent(ctx context.Context, event *PaymentEvent) error {
+	involvedCustomer, err := s.CustomerRepository.FindByID(ctx, event.CustomerID)
+	if errors.Is(err, datastore.ErrNotFound) {
+		log.Printf("drop event of customer %d, error: %v", event.CustomerID, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx = ContextWithEventID(ctx, event.EventID)
+	if event.Replay {
+		ctx = ContextWithReplay(ctx)
+	}
+
+	// The parent verified its own email, so it gets the events of an unverified sub-account
+	s.forwardToParent(ctx, involvedCustomer, event)
+
+	// The event stays stored, so it can be replayed once the email is verified
+	if !involvedCustomer.EmailVerified() {
+		log.Printf("skip event %d of customer %d, error: %v", event.EventID, event.CustomerID, errEmailNotVerified)
+		return nil
+	}
+
+	s.Notifier.Notify(ctx, involvedCustomer, event.Payload)
+	return nil
+}
+
+// forwardToParent notifies the parent of a sub-account that forwards its events too
+func (s *Server) forwardToParent(ctx context.Context, c *customer.Customer, event *PaymentEvent) {
+	if !c.IsSubAccount() || !c.ForwardToParent {
+		return
+	}
+
+	parent, err := s.CustomerRepository.FindByID(ctx, *c.ParentID)
+	if err != nil {
+		log.Printf("error when forwards event %d of customer %d to parent, error: %v", event.EventID, c.ID, err)
+		return
+	}
+	if !parent.EmailVerified() {
+		log.Printf("skip forwarding event %d of customer %d, error: %v", event.EventID, c.ID, errEmailNotVerified)
+		return
+	}
+
+	s.Notifier.Notify(ctx, parent, event.Payload)
+}
+
+// AuthRequest is a struct for register endpoint's request body, the password has to follow
+// the password policy
 type AuthRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required"`
+}
+
+// LoginRequest is a struct for login endpoint's request body, tokens are issued instead of
+// starting a session when Tokens is true
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required"`
+	Tokens   bool   `json:"tokens,omitempty"`
 }
 
 // SetCallbackURLRequest is a struct for set callback url endpoint's request body
 type SetCallbackURLRequest struct {
-	CallbackURL string `json:"callback_url"`
+	CallbackURL string `json:"callback_url" validate:"omitempty,url,max=2048"`
+}
+
+// SetCallbackChannelRequest is a struct for set callback channel endpoint's request body
+type SetCallbackChannelRequest struct {
+	Channel string                 `json:"channel" validate:"required"`
+	Config  customer.ChannelConfig `json:"config,omitempty"`
 }
 
 // AlfamartPaymentCallbackRequest is a struct that sent by alfamart service on payment callback
 type AlfamartPaymentCallbackRequest struct {
-	PaymentID   string    `json:"payment_id"`
-	PaymentCode string    `json:"payment_code"`
-	PaidAt      time.Time `json:"paid_at"`
-	ExternalID  string    `json:"external_id"`
-	CustomerID  uint64    `json:"customer_id"`
+	PaymentID   string    `json:"payment_id" validate:"required,max=64"`
+	PaymentCode string    `json:"payment_code" validate:"required,max=64"`
+	Amount      uint64    `json:"amount,omitempty"`
+	PaidAt      time.Time `json:"paid_at" validate:"required"`
+	ExternalID  string    `json:"external_id" validate:"required,max=255"`
+	CustomerID  uint64    `json:"customer_id" validate:"required"`
 }
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -14,7 +15,10 @@ import (
 	"github.com/abraithwaite/jeff"
 	"github.com/abraithwaite/jeff/memory"
 	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/datastore"
+	"github.com/ngavinsir/notification-service/event"
 	. "github.com/ngavinsir/notification-service/server"
+	"github.com/ngavinsir/notification-service/util/password"
 )
 
 type MockCustomerRepository struct {
@@ -25,32 +29,426 @@ type MockCustomerRepository struct {
 func (m *MockCustomerRepository) Save(ctx context.Context, customer *customer.Customer) error {
 	customerWithSameEmail, err := m.FindByEmail(ctx, customer.Email)
 	if err == nil && customerWithSameEmail != nil && customerWithSameEmail.ID != customer.ID {
-		return fmt.Errorf("violate email unique constraint")
+		return datastore.NewError(datastore.ErrDuplicateEmail, nil, "email %s is already registered", customer.Email)
 	}
 
 	if customer.ID == 0 {
 		customer.ID = uint64(len(m.customerByEmail) + 1)
 	}
 
-	m.customerByEmail[customer.Email] = customer
-	m.customerByID[customer.ID] = customer
+	for email, c := range m.customerByEmail {
+		if c.ID == customer.ID && email != customer.Email {
+			delete(m.customerByEmail, email)
+		}
+	}
+	saved := *customer
+	m.customerByEmail[customer.Email] = &saved
+	m.customerByID[customer.ID] = &saved
 	return nil
 }
 
 func (m *MockCustomerRepository) FindByID(_ context.Context, ID uint64) (*customer.Customer, error) {
 	customer, ok := m.customerByID[ID]
 	if !ok {
-		return nil, fmt.Errorf("can't find customer with id: %d", ID)
+		return nil, datastore.NewError(datastore.ErrNotFound, nil, "can't find customer with id: %d", ID)
 	}
-	return customer, nil
+	found := *customer
+	return &found, nil
 }
 
 func (m *MockCustomerRepository) FindByEmail(_ context.Context, email string) (*customer.Customer, error) {
 	customer, ok := m.customerByEmail[email]
 	if !ok {
-		return nil, fmt.Errorf("can't find customer with email: %s", email)
+		return nil, datastore.NewError(datastore.ErrNotFound, nil, "can't find customer with email: %s", email)
+	}
+	found := *customer
+	return &found, nil
+}
+
+func (m *MockCustomerRepository) FindByParentID(_ context.Context, parentID uint64) ([]*customer.Customer, error) {
+	var customers []*customer.Customer
+	for ID := uint64(1); ID <= uint64(len(m.customerByID)); ID++ {
+		c, ok := m.customerByID[ID]
+		if !ok || c.ParentID == nil || *c.ParentID != parentID {
+			continue
+		}
+		found := *c
+		customers = append(customers, &found)
+	}
+	return customers, nil
+}
+
+type MockMailer struct {
+	mu    sync.Mutex
+	mails []*Mail
+}
+
+func (m *MockMailer) Send(_ context.Context, mail *Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.mails = append(m.mails, mail)
+	return nil
+}
+
+func (m *MockMailer) Mails() []*Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*Mail(nil), m.mails...)
+}
+
+type MockEventRepository struct {
+	mu         sync.Mutex
+	events     []*event.Event
+	outbox     []*event.Outbox
+	deliveries *MockDeliveryRepository
+}
+
+func (m *MockEventRepository) Create(_ context.Context, e *event.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = uint64(len(m.events) + 1)
+	e.CreatedAt = time.Now()
+	m.events = append(m.events, e)
+	m.outbox = append(m.outbox, &event.Outbox{ID: uint64(len(m.outbox) + 1), EventID: e.ID, Event: e})
+	return nil
+}
+
+func (m *MockEventRepository) RelayOutbox(
+	_ context.Context,
+	limit int,
+	publish func(e *event.Event) error,
+) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	published := 0
+	for _, entry := range m.outbox {
+		if published == limit {
+			break
+		}
+		if entry.PublishedAt != nil {
+			continue
+		}
+		if err := publish(entry.Event); err != nil {
+			entry.Attempts++
+			entry.LastError = err.Error()
+			return published, nil
+		}
+		now := time.Now()
+		entry.PublishedAt = &now
+		published++
+	}
+	return published, nil
+}
+
+func (m *MockEventRepository) Archive(_ context.Context, e *event.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = uint64(len(m.events) + 1)
+	e.CreatedAt = time.Now()
+	m.events = append(m.events, e)
+	return nil
+}
+
+func (m *MockEventRepository) Assign(_ context.Context, ID, customerID uint64) (*event.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.events {
+		if e.ID == ID && e.Status == event.StatusOrphaned {
+			e.CustomerID = customerID
+			e.Status = event.StatusAccepted
+			e.Error = ""
+			m.outbox = append(m.outbox, &event.Outbox{ID: uint64(len(m.outbox) + 1), EventID: e.ID, Event: e})
+			return e, nil
+		}
+	}
+	return nil, datastore.NewError(datastore.ErrConflict, nil, "event with id %d is not orphaned", ID)
+}
+
+func (m *MockEventRepository) FindByID(_ context.Context, ID uint64) (*event.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.events {
+		if e.ID == ID {
+			return e, nil
+		}
+	}
+	return nil, datastore.NewError(datastore.ErrNotFound, nil, "can't find event with id: %d", ID)
+}
+
+func (m *MockEventRepository) Find(_ context.Context, filter *event.Filter) ([]*event.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []*event.Event
+	for _, e := range m.events {
+		if filter.CustomerID != 0 && e.CustomerID != filter.CustomerID {
+			continue
+		}
+		if filter.Status != "" && e.Status != filter.Status {
+			continue
+		}
+		if !filter.From.IsZero() && e.ReceivedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !e.ReceivedAt.Before(filter.To) {
+			continue
+		}
+		if filter.DeadLettered && !m.deliveries.lastDeadLettered(e) {
+			continue
+		}
+		if len(filter.PaymentIDs) > 0 {
+			var payload struct {
+				PaymentID string `json:"payment_id"`
+			}
+			json.Unmarshal(e.Payload, &payload)
+			if !containsString(filter.PaymentIDs, payload.PaymentID) {
+				continue
+			}
+		}
+		events = append([]*event.Event{e}, events...)
+	}
+	return events, nil
+}
+
+func (m *MockEventRepository) DeleteReceivedBefore(_ context.Context, t time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept []*event.Event
+	for _, e := range m.events {
+		if !e.ReceivedAt.Before(t) {
+			kept = append(kept, e)
+		}
+	}
+	deleted := int64(len(m.events) - len(kept))
+	m.events = kept
+	return deleted, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
-	return customer, nil
+	return false
+}
+
+type MockAPIKeyRepository struct {
+	mu   sync.Mutex
+	keys []*customer.APIKey
+}
+
+func (m *MockAPIKeyRepository) Create(_ context.Context, key *customer.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key.ID = uint64(len(m.keys) + 1)
+	key.CreatedAt = time.Now()
+	m.keys = append(m.keys, key)
+	return nil
+}
+
+func (m *MockAPIKeyRepository) FindByHash(_ context.Context, hash string) (*customer.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.keys {
+		if key.Hash == hash && key.RevokedAt == nil {
+			return key, nil
+		}
+	}
+	return nil, datastore.NewError(datastore.ErrNotFound, nil, "can't find api key")
+}
+
+func (m *MockAPIKeyRepository) FindByCustomerID(_ context.Context, customerID uint64) ([]*customer.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []*customer.APIKey
+	for _, key := range m.keys {
+		if key.CustomerID == customerID {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *MockAPIKeyRepository) Revoke(_ context.Context, customerID, ID uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.keys {
+		if key.ID == ID && key.CustomerID == customerID && key.RevokedAt == nil {
+			now := time.Now()
+			key.RevokedAt = &now
+			return nil
+		}
+	}
+	return datastore.NewError(datastore.ErrNotFound, nil, "can't find api key with id: %d", ID)
+}
+
+func (m *MockAPIKeyRepository) RevokeByCreator(_ context.Context, customerIDs []uint64, createdBy uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.keys {
+		if key.CreatedBy == nil || *key.CreatedBy != createdBy || key.RevokedAt != nil {
+			continue
+		}
+		for _, customerID := range customerIDs {
+			if key.CustomerID == customerID {
+				now := time.Now()
+				key.RevokedAt = &now
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MockAPIKeyRepository) Touch(_ context.Context, ID uint64, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.keys {
+		if key.ID == ID {
+			key.LastUsedAt = &t
+		}
+	}
+	return nil
+}
+
+type MockMemberRepository struct {
+	mu      sync.Mutex
+	members []*customer.Member
+}
+
+func (m *MockMemberRepository) Create(_ context.Context, member *customer.Member) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.members {
+		if stored.OrganizationID == member.OrganizationID && stored.CustomerID == member.CustomerID {
+			return datastore.NewError(datastore.ErrConflict, nil, "customer %d is already a member", member.CustomerID)
+		}
+	}
+	member.CreatedAt = time.Now()
+	stored := *member
+	m.members = append(m.members, &stored)
+	return nil
+}
+
+func (m *MockMemberRepository) Find(_ context.Context, organizationID, customerID uint64) (*customer.Member, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.members {
+		if stored.OrganizationID == organizationID && stored.CustomerID == customerID {
+			member := *stored
+			return &member, nil
+		}
+	}
+	return nil, datastore.NewError(datastore.ErrNotFound, nil, "can't find member %d of organization %d", customerID, organizationID)
+}
+
+func (m *MockMemberRepository) FindByOrganizationID(_ context.Context, organizationID uint64) ([]*customer.Member, error) {
+	return m.find(func(member *customer.Member) bool { return member.OrganizationID == organizationID }), nil
+}
+
+func (m *MockMemberRepository) FindByCustomerID(_ context.Context, customerID uint64) ([]*customer.Member, error) {
+	return m.find(func(member *customer.Member) bool { return member.CustomerID == customerID }), nil
+}
+
+func (m *MockMemberRepository) find(match func(member *customer.Member) bool) []*customer.Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var members []*customer.Member
+	for _, stored := range m.members {
+		if match(stored) {
+			member := *stored
+			members = append(members, &member)
+		}
+	}
+	return members
+}
+
+func (m *MockMemberRepository) Save(_ context.Context, member *customer.Member) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.members {
+		if stored.OrganizationID == member.OrganizationID && stored.CustomerID == member.CustomerID {
+			stored.Role = member.Role
+		}
+	}
+	return nil
+}
+
+func (m *MockMemberRepository) Delete(_ context.Context, organizationID, customerID uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, stored := range m.members {
+		if stored.OrganizationID == organizationID && stored.CustomerID == customerID {
+			m.members = append(m.members[:i], m.members[i+1:]...)
+			return nil
+		}
+	}
+	return datastore.NewError(datastore.ErrNotFound, nil, "can't find member %d of organization %d", customerID, organizationID)
+}
+
+type MockDeliveryRepository struct {
+	mu         sync.Mutex
+	deliveries []*event.Delivery
+}
+
+func (m *MockDeliveryRepository) Create(_ context.Context, delivery *event.Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delivery.ID = uint64(len(m.deliveries) + 1)
+	m.deliveries = append(m.deliveries, delivery)
+	return nil
+}
+
+func (m *MockDeliveryRepository) Find(_ context.Context, filter *event.Filter) ([]*event.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deliveries []*event.Delivery
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		delivery := m.deliveries[i]
+		if filter.CustomerID != 0 && delivery.CustomerID != filter.CustomerID {
+			continue
+		}
+		if filter.Status != "" && delivery.Status != filter.Status {
+			continue
+		}
+		found := *delivery
+		deliveries = append(deliveries, &found)
+	}
+	return deliveries, nil
+}
+
+func (m *MockDeliveryRepository) lastDeadLettered(e *event.Event) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		delivery := m.deliveries[i]
+		if delivery.EventID == e.ID && delivery.CustomerID == e.CustomerID {
+			return delivery.Status == event.DeliveryDeadLettered
+		}
+	}
+	return false
 }
 
 func TestServer_Register(t *testing.T) {
@@ -73,8 +471,26 @@ func TestServer_Register(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if statusCode := registerResponse.StatusCode; statusCode == http.StatusOK {
-			t.Errorf("handler should returned error status code: got %v", statusCode)
+		if statusCode := registerResponse.StatusCode; statusCode != http.StatusConflict {
+			t.Errorf("handler returned wrong status code: got %v, want %v", statusCode, http.StatusConflict)
+		}
+	})
+
+	t.Run("Password breaks policy", func(t *testing.T) {
+		registerResponse, err := register(handler, "other@example.com", "other@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if statusCode := registerResponse.StatusCode; statusCode != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v, want %v", statusCode, http.StatusBadRequest)
+		}
+		var errResponse ErrResponse
+		if err := json.NewDecoder(registerResponse.Body).Decode(&errResponse); err != nil {
+			t.Fatal(err)
+		}
+		if len(errResponse.Details) != 1 || errResponse.Details[0].Field != "password" {
+			t.Errorf("Want validation error of password, got %v", errResponse.Details)
 		}
 	})
 }
@@ -83,7 +499,7 @@ func TestServer_Login(t *testing.T) {
 	server := setupMockServer()
 
 	// Register customer
-	if err := mustRegister(server.RegisterHandler(), "example@example.com", "password"); err != nil {
+	if err := mustRegisterVerified(server, "example@example.com", "password"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -106,13 +522,58 @@ func TestServer_Login(t *testing.T) {
 			t.Errorf("handler should returned error status code: got %v, want %v", statusCode, http.StatusUnauthorized)
 		}
 	})
+
+	t.Run("Legacy hash is upgraded", func(t *testing.T) {
+		selectedCustomer, err := server.CustomerRepository.FindByEmail(context.Background(), "example@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		selectedCustomer.Password, err = (&password.BcryptHasher{Cost: 4}).Hash("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := server.CustomerRepository.Save(context.Background(), selectedCustomer); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := mustLogin(loginHandler, "example@example.com", "password"); err != nil {
+			t.Fatal(err)
+		}
+
+		selectedCustomer, err = server.CustomerRepository.FindByEmail(context.Background(), "example@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, rehash, err := server.Passwords.Verify("password", selectedCustomer.Password); err != nil || rehash {
+			t.Errorf("Want password rehashed with the current hasher, got %s", selectedCustomer.Password)
+		}
+	})
+
+	t.Run("Legacy password longer than 72 bytes", func(t *testing.T) {
+		longPassword := strings.Repeat("password", 10)
+		selectedCustomer, err := server.CustomerRepository.FindByEmail(context.Background(), "example@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		selectedCustomer.Password, err = (&password.BcryptHasher{Cost: 4}).Hash(longPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := server.CustomerRepository.Save(context.Background(), selectedCustomer); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := mustLogin(loginHandler, "example@example.com", longPassword); err != nil {
+			t.Fatal(err)
+		}
+	})
 }
 
 func TestServer_SetCallbackURL(t *testing.T) {
 	server := setupMockServer()
 
 	// Register customer
-	if err := mustRegister(server.RegisterHandler(), "example@example.com", "password"); err != nil {
+	if err := mustRegisterVerified(server, "example@example.com", "password"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -162,6 +623,52 @@ func TestServer_SetCallbackURL(t *testing.T) {
 	})
 }
 
+func TestServer_SetCallbackChannel(t *testing.T) {
+	server := setupMockServer()
+
+	// Register customer
+	if err := mustRegisterVerified(server, "example@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Login customer
+	loginResponse, err := mustLogin(server.LoginHandler(), "example@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := server.Jeff.WrapFunc(server.SetCallbackChannelHandler())
+
+	t.Run("Unknown channel", func(t *testing.T) {
+		response, err := setCallbackChannel(handler, "pigeon", loginResponse.Cookies())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := response.StatusCode, http.StatusBadRequest; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Channel is matching", func(t *testing.T) {
+		response, err := setCallbackChannel(handler, customer.ChannelSSE, loginResponse.Cookies())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := response.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		customerByEmail, err := server.CustomerRepository.FindByEmail(context.Background(), "example@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := customerByEmail.Callback.Channel, customer.ChannelSSE; got != want {
+			t.Errorf("Want customer callback's channel %s, got %s", want, got)
+		}
+	})
+}
+
 func TestServer_AlfamartPaymentCallback(t *testing.T) {
 	var wg sync.WaitGroup
 	server := setupMockServer()
@@ -193,7 +700,7 @@ func TestServer_AlfamartPaymentCallback(t *testing.T) {
 	defer mockCustomerServer.Close()
 
 	// Set callback url
-	if err := mustRegister(server.RegisterHandler(), "example@example.com", "password"); err != nil {
+	if err := mustRegisterVerified(server, "example@example.com", "password"); err != nil {
 		t.Fatal(err)
 	}
 	loginResponse, err := mustLogin(server.LoginHandler(), "example@example.com", "password")
@@ -220,16 +727,44 @@ func TestServer_AlfamartPaymentCallback(t *testing.T) {
 }
 
 func setupMockServer() *Server {
-	return &Server{
+	channels := NewChannelRegistry()
+	channels.Register(customer.ChannelHTTP, NewWebhookChannel(&http.Client{}))
+	channels.Register(customer.ChannelSSE, NewSSEChannel())
+
+	deliveryRepository := &MockDeliveryRepository{}
+	eventRepository := &MockEventRepository{deliveries: deliveryRepository}
+	eventBus := NewInProcessEventBus()
+	sessionStore := memory.New()
+
+	server := &Server{
+		APIKeyRepository:   &MockAPIKeyRepository{},
+		MemberRepository:   &MockMemberRepository{},
+		EventRepository:    eventRepository,
+		DeliveryRepository: deliveryRepository,
+		AdminAPIKey:        "admin-key",
+		Channels:           channels,
+		Notifier:           NewNotifier(channels, deliveryRepository),
+		EventBus:           eventBus,
+		OutboxRelay:        NewOutboxRelay(eventRepository, eventBus),
 		CustomerRepository: &MockCustomerRepository{
 			customerByEmail: make(map[string]*customer.Customer),
 			customerByID:    make(map[uint64]*customer.Customer),
 		},
+		Sessions:       NewSessionManager(sessionStore),
+		EmailVerifier:  NewEmailVerifier([]byte("secret")),
+		TwoFactor:      NewTwoFactor([]byte("secret")),
+		PasswordPolicy: password.NewPolicy(),
+		Passwords:      password.NewHashing(),
+		Mailer:         &MockMailer{},
 		Jeff: jeff.New(
-			memory.New(),
+			sessionStore,
 			jeff.Insecure,
 		),
 	}
+	go server.OutboxRelay.Run(context.Background())
+	server.RunWorkers(context.Background(), 1)
+
+	return server
 }
 
 func mustRegister(handler http.HandlerFunc, email, password string) error {
@@ -243,6 +778,31 @@ func mustRegister(handler http.HandlerFunc, email, password string) error {
 	return nil
 }
 
+// mustRegisterVerified registers the customer and verifies its email
+func mustRegisterVerified(server *Server, email, password string) error {
+	if err := mustRegister(server.RegisterHandler(), email, password); err != nil {
+		return err
+	}
+
+	registeredCustomer, err := server.CustomerRepository.FindByEmail(context.Background(), email)
+	if err != nil {
+		return err
+	}
+	token, err := server.EmailVerifier.Token(registeredCustomer)
+	if err != nil {
+		return err
+	}
+
+	response, err := sendRequest(server.VerifyEmailHandler(), "POST", "/verify-email", &VerifyEmailRequest{Token: token}, nil)
+	if err != nil {
+		return err
+	}
+	if statusCode := response.StatusCode; statusCode != http.StatusOK {
+		return fmt.Errorf("error verify email")
+	}
+	return nil
+}
+
 func mustLogin(handler http.HandlerFunc, email, password string) (*http.Response, error) {
 	loginResponse, err := login(handler, email, password)
 	if err != nil {
@@ -288,6 +848,13 @@ func setCallbackURL(handler http.HandlerFunc, callbackURL string, cookies []*htt
 	return sendRequest(handler, "POST", "/callback_url", request, cookies)
 }
 
+func setCallbackChannel(handler http.HandlerFunc, channel string, cookies []*http.Cookie) (*http.Response, error) {
+	request := &SetCallbackChannelRequest{
+		Channel: channel,
+	}
+	return sendRequest(handler, "POST", "/callback_channel", request, cookies)
+}
+
 func sendRequest(
 	handler http.HandlerFunc,
 	method string,
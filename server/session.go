@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/abraithwaite/jeff"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/ngavinsir/notification-service/customer"
+)
+
+// sessionCookieName is the cookie jeff keeps the session in
+const sessionCookieName = "_gosession"
+
+// SessionMeta is stored with every session started by login
+type SessionMeta struct {
+	CreatedAt time.Time `json:"created_at"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	TwoFactor bool      `json:"two_factor,omitempty"`
+}
+
+// Session is a struct for list sessions endpoint's response body, ID identifies the session
+// without revealing its token
+type Session struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Current   bool      `json:"current"`
+}
+
+// SessionManager lists and revokes single sessions of a customer in the storage jeff keeps
+// them in, jeff itself can only delete every session of a customer at once
+type SessionManager struct {
+	Storage jeff.Storage
+}
+
+// NewSessionManager returns new session manager of sessions in storage
+func NewSessionManager(storage jeff.Storage) *SessionManager {
+	return &SessionManager{
+		Storage: storage,
+	}
+}
+
+// List returns sessions of the key that aren't expired
+func (m *SessionManager) List(ctx context.Context, key []byte) (jeff.SessionList, error) {
+	stored, err := m.Storage.Fetch(ctx, key)
+	if err != nil || stored == nil {
+		return nil, err
+	}
+
+	var sessions jeff.SessionList
+	if _, err := sessions.UnmarshalMsg(stored); err != nil {
+		return nil, err
+	}
+
+	active := make(jeff.SessionList, 0, len(sessions))
+	for _, session := range sessions {
+		if session.Exp.After(time.Now()) {
+			active = append(active, session)
+		}
+	}
+	return active, nil
+}
+
+// Revoke deletes sessions of the key for which revoke returns true and returns the number
+// of deleted sessions
+func (m *SessionManager) Revoke(ctx context.Context, key []byte, revoke func(session jeff.Session) bool) (int, error) {
+	sessions, err := m.List(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	kept := make(jeff.SessionList, 0, len(sessions))
+	exp := time.Now()
+	for _, session := range sessions {
+		if revoke(session) {
+			continue
+		}
+		kept = append(kept, session)
+		if session.Exp.After(exp) {
+			exp = session.Exp
+		}
+	}
+	revoked := len(sessions) - len(kept)
+	if revoked == 0 {
+		return 0, nil
+	}
+
+	if len(kept) == 0 {
+		return revoked, m.Storage.Delete(ctx, key)
+	}
+	stored, err := kept.MarshalMsg(nil)
+	if err != nil {
+		return 0, err
+	}
+	return revoked, m.Storage.Store(ctx, key, stored, exp)
+}
+
+// RevokeAll deletes every session of the customer except the session with keepToken
+func (m *SessionManager) RevokeAll(ctx context.Context, c *customer.Customer, keepToken []byte) error {
+	_, err := m.Revoke(ctx, []byte(c.Email), func(session jeff.Session) bool {
+		return string(session.Token) != string(keepToken)
+	})
+	return err
+}
+
+// sessionID identifies the session by a hash of its token
+func sessionID(session jeff.Session) string {
+	hash := sha256.Sum256(session.Token)
+	return hex.EncodeToString(hash[:8])
+}
+
+// newSessionMeta returns encoded meta of a session started by the request, twoFactor tells
+// whether the login passed the second factor
+func newSessionMeta(r *http.Request, twoFactor bool) ([]byte, error) {
+	return json.Marshal(&SessionMeta{
+		CreatedAt: time.Now(),
+		IP:        remoteIP(r),
+		UserAgent: r.UserAgent(),
+		TwoFactor: twoFactor,
+	})
+}
+
+// LogoutHandler handles request for ending the current session, or revoking the current
+// login's tokens when authenticated by access token
+func (s *Server) LogoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if claims, ok := r.Context().Value(accessClaimsContextKey).(*AccessClaims); ok {
+			if err := s.Tokens.Revoke(r.Context(), claims.Family); err != nil {
+				render.Render(w, r, ErrInternalServer(err))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		current := jeff.ActiveSession(r.Context())
+		if len(current.Token) == 0 {
+			render.Render(w, r, ErrBadRequest(fmt.Errorf("api keys can't log out, revoke the key instead")))
+			return
+		}
+
+		_, err := s.Sessions.Revoke(r.Context(), current.Key, func(session jeff.Session) bool {
+			return string(session.Token) == string(current.Token)
+		})
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    "deleted",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+		})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ListSessionsHandler handles request for listing the customer's active sessions
+func (s *Server) ListSessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		selectedCustomer, err := s.authenticatedCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		sessions, err := s.Sessions.List(r.Context(), []byte(selectedCustomer.Email))
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+
+		current := jeff.ActiveSession(r.Context())
+		response := make([]*Session, 0, len(sessions))
+		for _, session := range sessions {
+			var meta SessionMeta
+			// Sessions started before meta was stored have none
+			json.Unmarshal(session.Meta, &meta)
+
+			response = append(response, &Session{
+				ID:        sessionID(session),
+				CreatedAt: meta.CreatedAt,
+				ExpiresAt: session.Exp,
+				IP:        meta.IP,
+				UserAgent: meta.UserAgent,
+				Current:   string(session.Token) == string(current.Token),
+			})
+		}
+
+		render.JSON(w, r, response)
+	}
+}
+
+// RevokeSessionHandler handles request for revoking one of the customer's sessions
+func (s *Server) RevokeSessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		selectedCustomer, err := s.authenticatedCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		id := chi.URLParam(r, "sessionID")
+		revoked, err := s.Sessions.Revoke(r.Context(), []byte(selectedCustomer.Email), func(session jeff.Session) bool {
+			return sessionID(session) == id
+		})
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		if revoked == 0 {
+			render.Render(w, r, ErrNotFound(fmt.Errorf("can't find session with id: %s", id)))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RevokeOtherSessionsHandler handles request for revoking every session of the customer
+// except the current one
+func (s *Server) RevokeOtherSessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		selectedCustomer, err := s.authenticatedCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		if err := s.Sessions.RevokeAll(r.Context(), selectedCustomer, jeff.ActiveSession(r.Context()).Token); err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
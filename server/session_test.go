@@ -0,0 +1,107 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestServer_Sessions(t *testing.T) {
+	server := setupMockServer()
+	router := server.Router()
+
+	if err := mustRegisterVerified(server, "example@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, url, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	login := func(userAgent string) []*http.Cookie {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/login", bytes.NewBufferString(`{"email": "example@example.com", "password": "password"}`))
+		req.Header.Set("User-Agent", userAgent)
+		router.ServeHTTP(rr, req)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("login returned wrong status code: got %v, want %v", got, want)
+		}
+		return rr.Result().Cookies()
+	}
+	listSessions := func(cookies []*http.Cookie) []*Session {
+		rr := request("GET", "/sessions", "", cookies)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		var sessions []*Session
+		if err := json.NewDecoder(rr.Body).Decode(&sessions); err != nil {
+			t.Fatal(err)
+		}
+		return sessions
+	}
+
+	laptop := login("laptop")
+	phone := login("phone")
+
+	var phoneSession *Session
+	t.Run("List", func(t *testing.T) {
+		sessions := listSessions(laptop)
+		if len(sessions) != 2 {
+			t.Fatalf("Want 2 sessions, got %d", len(sessions))
+		}
+		for _, session := range sessions {
+			if session.Current != (session.UserAgent == "laptop") {
+				t.Errorf("Want only the laptop session to be current, got %+v", session)
+			}
+			if session.CreatedAt.IsZero() || session.IP == "" {
+				t.Errorf("Want session with created time and ip, got %+v", session)
+			}
+			if session.UserAgent == "phone" {
+				phoneSession = session
+			}
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		if got, want := request("DELETE", "/sessions/"+phoneSession.ID, "", laptop).Code, http.StatusNoContent; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got := request("GET", "/sessions", "", phone).Code; got == http.StatusOK {
+			t.Errorf("revoked session isn't logged out: got %v", got)
+		}
+		if got, want := request("DELETE", "/sessions/"+phoneSession.ID, "", laptop).Code, http.StatusNotFound; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Revoke others", func(t *testing.T) {
+		phone := login("phone")
+		if got, want := request("DELETE", "/sessions", "", laptop).Code, http.StatusNoContent; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got := request("GET", "/sessions", "", phone).Code; got == http.StatusOK {
+			t.Errorf("other session isn't logged out: got %v", got)
+		}
+		if sessions := listSessions(laptop); len(sessions) != 1 || !sessions[0].Current {
+			t.Errorf("Want only the current session left, got %+v", sessions)
+		}
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		if got, want := request("POST", "/logout", "", laptop).Code, http.StatusNoContent; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got := request("GET", "/sessions", "", laptop).Code; got == http.StatusOK {
+			t.Errorf("session isn't logged out: got %v", got)
+		}
+	})
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/ngavinsir/notification-service/customer"
+)
+
+// TLS modes of smtp connection
+const (
+	SMTPTLSNone     = "none"
+	SMTPTLSStartTLS = "starttls"
+	SMTPTLSImplicit = "tls"
+)
+
+const defaultEmailTextTemplate = `Hi {{.Customer.Email}},
+
+A payment has been received with the following details:
+{{range $field, $value := .Payment}}
+- {{$field}}: {{$value}}{{end}}
+`
+
+const defaultEmailHTMLTemplate = `<html>
+<body>
+<p>Hi {{.Customer.Email}},</p>
+<p>A payment has been received with the following details:</p>
+<table>
+{{- range $field, $value := .Payment}}
+<tr><td>{{$field}}</td><td>{{$value}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`
+
+// SMTPConfig holds smtp server and email template settings
+type SMTPConfig struct {
+	Host             string
+	Port             string
+	Username         string
+	Password         string
+	From             string
+	Subject          string
+	TLS              string
+	TextTemplatePath string
+	HTMLTemplatePath string
+}
+
+// NewSMTPConfigFromEnv returns smtp config read from environment variables
+func NewSMTPConfigFromEnv() SMTPConfig {
+	config := SMTPConfig{
+		Host:             os.Getenv("SMTP_HOST"),
+		Port:             os.Getenv("SMTP_PORT"),
+		Username:         os.Getenv("SMTP_USERNAME"),
+		Password:         os.Getenv("SMTP_PASSWORD"),
+		From:             os.Getenv("SMTP_FROM"),
+		Subject:          os.Getenv("SMTP_SUBJECT"),
+		TLS:              os.Getenv("SMTP_TLS"),
+		TextTemplatePath: os.Getenv("SMTP_TEXT_TEMPLATE"),
+		HTMLTemplatePath: os.Getenv("SMTP_HTML_TEMPLATE"),
+	}
+	if config.Port == "" {
+		config.Port = "587"
+	}
+	if config.Subject == "" {
+		config.Subject = "Payment received"
+	}
+	if config.TLS == "" {
+		config.TLS = SMTPTLSStartTLS
+	}
+
+	return config
+}
+
+// EmailTemplateData is the data passed to the email templates
+type EmailTemplateData struct {
+	Customer *customer.Customer
+	Payment  map[string]interface{}
+}
+
+// SMTPChannel delivers notification as an email sent through smtp server
+type SMTPChannel struct {
+	Config       SMTPConfig
+	textTemplate *texttemplate.Template
+	htmlTemplate *htmltemplate.Template
+}
+
+// NewSMTPChannel returns new smtp channel with parsed email templates
+func NewSMTPChannel(config SMTPConfig) (*SMTPChannel, error) {
+	switch config.TLS {
+	case SMTPTLSNone, SMTPTLSStartTLS, SMTPTLSImplicit:
+	default:
+		return nil, fmt.Errorf("unknown smtp tls mode: %s", config.TLS)
+	}
+
+	textTemplate, err := parseTextTemplate(config.TextTemplatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlTemplate, err := parseHTMLTemplate(config.HTMLTemplatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SMTPChannel{
+		Config:       config,
+		textTemplate: textTemplate,
+		htmlTemplate: htmlTemplate,
+	}, nil
+}
+
+// Send emails the notification body to config's recipient, or customer's email if not set
+func (c *SMTPChannel) Send(ctx context.Context, customer *customer.Customer, body interface{}) error {
+	payment, err := toFields(body)
+	if err != nil {
+		return err
+	}
+
+	to := customer.Email
+	if customer.Callback != nil && customer.Callback.Config["to"] != "" {
+		to = customer.Callback.Config["to"]
+	}
+
+	message, err := c.buildMessage(to, &EmailTemplateData{
+		Customer: customer,
+		Payment:  payment,
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendSMTP(ctx, c.Config, to, message)
+}
+
+// ValidateConfig checks that config's recipient, if set, is a valid email address
+func (c *SMTPChannel) ValidateConfig(_ *customer.Customer, config customer.ChannelConfig) error {
+	to, ok := config["to"]
+	if !ok {
+		return nil
+	}
+
+	if _, err := mail.ParseAddress(to); err != nil {
+		return fmt.Errorf("to must be a valid email address")
+	}
+	return nil
+}
+
+func (c *SMTPChannel) buildMessage(to string, data *EmailTemplateData) ([]byte, error) {
+	var message bytes.Buffer
+	mw := multipart.NewWriter(&message)
+
+	headers := []string{
+		"From: " + c.Config.From,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", c.Config.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + mw.Boundary(),
+	}
+	for _, header := range headers {
+		message.WriteString(header + "\r\n")
+	}
+	message.WriteString("\r\n")
+
+	if err := writePart(mw, "text/plain", func(w io.Writer) error {
+		return c.textTemplate.Execute(w, data)
+	}); err != nil {
+		return nil, err
+	}
+	if err := writePart(mw, "text/html", func(w io.Writer) error {
+		return c.htmlTemplate.Execute(w, data)
+	}); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return message.Bytes(), nil
+}
+
+// sendSMTP sends the message to the recipient through the smtp server of config
+func sendSMTP(ctx context.Context, config SMTPConfig, to string, message []byte) error {
+	addr := net.JoinHostPort(config.Host, config.Port)
+	tlsConfig := &tls.Config{ServerName: config.Host}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if config.TLS == SMTPTLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if config.TLS == SMTPTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server doesn't support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if config.Username != "" {
+		auth := smtp.PlainAuth("", config.Username, config.Password, config.Host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(config.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func writePart(mw *multipart.Writer, contentType string, execute func(w io.Writer) error) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	qw := quotedprintable.NewWriter(part)
+	if err := execute(qw); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+func parseTextTemplate(path string) (*texttemplate.Template, error) {
+	if path == "" {
+		return texttemplate.New("text").Parse(defaultEmailTextTemplate)
+	}
+	return texttemplate.ParseFiles(path)
+}
+
+func parseHTMLTemplate(path string) (*htmltemplate.Template, error) {
+	if path == "" {
+		return htmltemplate.New("html").Parse(defaultEmailHTMLTemplate)
+	}
+	return htmltemplate.ParseFiles(path)
+}
+
+// toFields converts notification body into map so templates can refer to the json field names
+func toFields(body interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,180 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ngavinsir/notification-service/customer"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+// mockSMTPServer is a minimal smtp server that captures every received message
+type mockSMTPServer struct {
+	listener net.Listener
+	messages chan mockSMTPMessage
+}
+
+type mockSMTPMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+func newMockSMTPServer(t *testing.T) *mockSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &mockSMTPServer{
+		listener: listener,
+		messages: make(chan mockSMTPMessage, 10),
+	}
+	go s.serve()
+
+	return s
+}
+
+func (s *mockSMTPServer) Addr() (string, string) {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host, port
+}
+
+func (s *mockSMTPServer) Close() {
+	s.listener.Close()
+}
+
+func (s *mockSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *mockSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	var message mockSMTPMessage
+	reply("220 localhost ESMTP mock")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message.From = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.To = append(message.To, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			message.Data = data.String()
+			s.messages <- message
+			message = mockSMTPMessage{}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPChannel_Send(t *testing.T) {
+	smtpServer := newMockSMTPServer(t)
+	defer smtpServer.Close()
+
+	host, port := smtpServer.Addr()
+	channel, err := NewSMTPChannel(SMTPConfig{
+		Host:    host,
+		Port:    port,
+		From:    "noreply@example.com",
+		Subject: "Payment received",
+		TLS:     SMTPTLSNone,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selectedCustomer := customer.New("merchant@example.com", "")
+	paidAt, _ := time.Parse(time.RFC3339, "2020-10-17T07:41:33.866Z")
+	payment := &AlfamartPaymentCallbackRequest{
+		PaymentID:   "123123123",
+		PaymentCode: "XYZ123",
+		PaidAt:      paidAt,
+		ExternalID:  "order-123",
+		CustomerID:  1,
+	}
+
+	if err := channel.Send(context.Background(), selectedCustomer, payment); err != nil {
+		t.Fatal(err)
+	}
+
+	var message mockSMTPMessage
+	select {
+	case message = <-smtpServer.messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("smtp server didn't receive any message")
+	}
+
+	t.Run("Envelope is matching", func(t *testing.T) {
+		if got, want := message.From, "noreply@example.com"; got != want {
+			t.Errorf("Want sender %s, got %s", want, got)
+		}
+		if len(message.To) != 1 || message.To[0] != selectedCustomer.Email {
+			t.Errorf("Want recipient %s, got %v", selectedCustomer.Email, message.To)
+		}
+	})
+
+	t.Run("Message contains text and html parts", func(t *testing.T) {
+		for _, want := range []string{
+			"Subject: Payment received",
+			"Content-Type: multipart/alternative",
+			"Content-Type: text/plain; charset=utf-8",
+			"Content-Type: text/html; charset=utf-8",
+			"payment_id: 123123123",
+			"<td>external_id</td><td>order-123</td>",
+		} {
+			if !strings.Contains(message.Data, want) {
+				t.Errorf("Want message to contain %q, got %s", want, message.Data)
+			}
+		}
+	})
+}
+
+func TestNewSMTPChannel_UnknownTLSMode(t *testing.T) {
+	if _, err := NewSMTPChannel(SMTPConfig{TLS: "ssl3"}); err == nil {
+		t.Error("Want error for unknown tls mode")
+	}
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/ngavinsir/notification-service/customer"
+)
+
+// SSEChannel delivers notification as server-sent event to every stream opened by the customer
+// on this instance, or on every instance when notifications are fanned out through redis
+type SSEChannel struct {
+	mu          sync.RWMutex
+	subscribers map[uint64]map[chan []byte]struct{}
+
+	// pool is set when notifications are published to redis, every instance subscribes to the
+	// customers with open streams on it
+	pool   *redis.Pool
+	listen sync.Once
+	pubSub *redis.PubSubConn
+	done   chan struct{}
+	close  sync.Once
+}
+
+// NewSSEChannel returns new sse channel without any subscriber, it only reaches streams on
+// this instance
+func NewSSEChannel() *SSEChannel {
+	return &SSEChannel{
+		subscribers: make(map[uint64]map[chan []byte]struct{}),
+	}
+}
+
+// NewRedisSSEChannel returns new sse channel fanning out notifications through redis pub/sub,
+// so workers reach streams opened on any instance sharing the redis
+func NewRedisSSEChannel(pool *redis.Pool) *SSEChannel {
+	channel := NewSSEChannel()
+	channel.pool = pool
+	channel.done = make(chan struct{})
+	return channel
+}
+
+// Close stops receiving notifications published to redis, streams on this instance get no
+// more notifications
+func (c *SSEChannel) Close() {
+	if c.pool == nil {
+		return
+	}
+	c.close.Do(func() { close(c.done) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pubSub != nil {
+		c.pubSub.Close()
+	}
+}
+
+// Subscribe registers new stream for the customer, call the returned function to unsubscribe
+func (c *SSEChannel) Subscribe(customerID uint64) (<-chan []byte, func()) {
+	events := make(chan []byte, 16)
+
+	if c.pool != nil {
+		c.listen.Do(func() { go c.receive() })
+	}
+
+	c.mu.Lock()
+	if c.subscribers[customerID] == nil {
+		c.subscribers[customerID] = make(map[chan []byte]struct{})
+		c.updateSubscription("SUBSCRIBE", customerID)
+	}
+	c.subscribers[customerID][events] = struct{}{}
+	c.mu.Unlock()
+
+	return events, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subscribers[customerID], events)
+		if len(c.subscribers[customerID]) == 0 {
+			delete(c.subscribers, customerID)
+			c.updateSubscription("UNSUBSCRIBE", customerID)
+		}
+	}
+}
+
+// updateSubscription subscribes or unsubscribes the redis connection, c.mu must be held. A
+// failed or missing connection is left to receive, it subscribes again on reconnecting.
+func (c *SSEChannel) updateSubscription(command string, customerID uint64) {
+	if c.pubSub == nil {
+		return
+	}
+	err := c.pubSub.Conn.Send(command, sseChannelKey(customerID))
+	if err == nil {
+		err = c.pubSub.Conn.Flush()
+	}
+	if err != nil {
+		log.Printf("error when updates sse subscription of customer %d, error: %v", customerID, err)
+	}
+}
+
+// receive passes notifications published to redis to the streams on this instance, it
+// reconnects and subscribes again to every customer with open streams when the connection fails
+func (c *SSEChannel) receive() {
+	for {
+		// Closing a pooled connection unsubscribes and waits for the replies, which receive
+		// reads instead, so the subscription has its own connection
+		conn, err := c.pool.Dial()
+		if err != nil {
+			if !c.wait(err) {
+				return
+			}
+			continue
+		}
+		pubSub := &redis.PubSubConn{Conn: conn}
+
+		c.mu.Lock()
+		select {
+		case <-c.done:
+			c.mu.Unlock()
+			pubSub.Close()
+			return
+		default:
+		}
+		c.pubSub = pubSub
+		for customerID := range c.subscribers {
+			c.updateSubscription("SUBSCRIBE", customerID)
+		}
+		c.mu.Unlock()
+
+		err = c.receiveMessages(pubSub)
+
+		c.mu.Lock()
+		c.pubSub = nil
+		c.mu.Unlock()
+		pubSub.Close()
+
+		if !c.wait(err) {
+			return
+		}
+	}
+}
+
+// wait waits a second before reconnecting after the error, it reports false when the channel
+// is closed meanwhile
+func (c *SSEChannel) wait(err error) bool {
+	select {
+	case <-c.done:
+		return false
+	case <-time.After(time.Second):
+		log.Printf("error when receives sse notifications, reconnecting, error: %v", err)
+		return true
+	}
+}
+
+// receiveMessages passes published notifications to the streams until the connection fails
+func (c *SSEChannel) receiveMessages(pubSub *redis.PubSubConn) error {
+	for {
+		switch message := pubSub.ReceiveWithTimeout(0).(type) {
+		case redis.Message:
+			customerID, err := strconv.ParseUint(strings.TrimPrefix(message.Channel, "sse:"), 10, 64)
+			if err != nil {
+				continue
+			}
+			c.deliver(customerID, message.Data)
+		case error:
+			return message
+		}
+	}
+}
+
+// deliver passes the notification to customer's open streams on this instance, it returns
+// the number of streams that weren't full
+func (c *SSEChannel) deliver(customerID uint64, data []byte) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	delivered := 0
+	for events := range c.subscribers[customerID] {
+		select {
+		case events <- data:
+			delivered++
+		default:
+		}
+	}
+	return delivered
+}
+
+func sseChannelKey(customerID uint64) string {
+	return "sse:" + strconv.FormatUint(customerID, 10)
+}
+
+// Send publishes the body to customer's open streams, it fails when customer has no open stream.
+// Through redis it only fails when no instance has an open stream of the customer, full
+// streams on other instances drop the notification.
+func (c *SSEChannel) Send(ctx context.Context, customer *customer.Customer, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	if c.pool != nil {
+		conn := c.pool.Get()
+		defer conn.Close()
+
+		receivers, err := redis.Int(conn.Do("PUBLISH", sseChannelKey(customer.ID), data))
+		if err != nil {
+			return err
+		}
+		if receivers == 0 {
+			return fmt.Errorf("customer doesn't have open event stream")
+		}
+		return nil
+	}
+
+	c.mu.RLock()
+	streams := len(c.subscribers[customer.ID])
+	c.mu.RUnlock()
+	if streams == 0 {
+		return fmt.Errorf("customer doesn't have open event stream")
+	}
+	if c.deliver(customer.ID, data) == 0 {
+		return fmt.Errorf("every event stream of customer is full")
+	}
+
+	return nil
+}
+
+// ValidateConfig accepts any config, sse channel doesn't need any
+func (c *SSEChannel) ValidateConfig(_ *customer.Customer, config customer.ChannelConfig) error {
+	return nil
+}
+
+// ServeStream streams customer's notifications as server-sent events until the request is done
+func (c *SSEChannel) ServeStream(w http.ResponseWriter, r *http.Request, customerID uint64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := c.Subscribe(customerID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-events:
+			fmt.Fprintf(w, "event: notification\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
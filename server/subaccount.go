@@ -0,0 +1,169 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/datastore"
+)
+
+// errNestedSubAccount is returned when a sub-account tries to create its own sub-account
+var errNestedSubAccount = errors.New("sub-accounts can't have sub-accounts")
+
+// CreateSubAccountHandler handles request for creating a sub-account of the customer, its id
+// is the customer_id of its payment callbacks. Setting its callback url needs the second
+// factor like changing the customer's own callback url.
+func (s *Server) CreateSubAccountHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateSubAccountRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		parent, err := s.accountCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if parent.IsSubAccount() {
+			render.Render(w, r, ErrBadRequest(errNestedSubAccount))
+			return
+		}
+		if err := requireVerifiedEmail(parent); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if req.CallbackURL != "" {
+			if err := s.requireActingTwoFactor(r, parent); err != nil {
+				render.Render(w, r, ErrFrom(err))
+				return
+			}
+		}
+
+		subAccount := customer.NewSubAccount(parent, req.Email)
+		subAccount.ForwardToParent = req.ForwardToParent
+		subAccount.Callback = customer.NewCallback(req.CallbackURL, uint(subAccount.ID))
+		if err := s.CustomerRepository.Save(r.Context(), subAccount); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		// Events of the sub-account are skipped until its owner verifies the email, the
+		// parent can't vouch for an email it doesn't own
+		if err := s.sendVerificationEmail(r.Context(), subAccount); err != nil {
+			log.Printf("error when sends verification email to customer %d, error: %v", subAccount.ID, err)
+		}
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, subAccount)
+	}
+}
+
+// ListSubAccountsHandler handles request for listing the customer's sub-accounts
+func (s *Server) ListSubAccountsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parent, err := s.accountCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		subAccounts, err := s.CustomerRepository.FindByParentID(r.Context(), parent.ID)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.JSON(w, r, subAccounts)
+	}
+}
+
+// UpdateSubAccountHandler handles request for changing whether a sub-account forwards its
+// events to the customer
+func (s *Server) UpdateSubAccountHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req UpdateSubAccountRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		customerID, err := strconv.ParseUint(chi.URLParam(r, "customerID"), 10, 64)
+		if err != nil {
+			render.Render(w, r, ErrFrom(&ValidationError{Fields: []FieldError{{
+				Field:   "customer_id",
+				Message: "is invalid",
+			}}}))
+			return
+		}
+
+		parent, err := s.accountCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		subAccount, err := s.CustomerRepository.FindByID(r.Context(), customerID)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		// Other customers look the same as missing ones
+		if !subAccount.IsSubAccount() || *subAccount.ParentID != parent.ID {
+			render.Render(w, r, ErrFrom(datastore.NewError(datastore.ErrNotFound, nil, "can't find sub-account with id: %d", customerID)))
+			return
+		}
+
+		subAccount.ForwardToParent = *req.ForwardToParent
+		if err := s.CustomerRepository.Save(r.Context(), subAccount); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.JSON(w, r, subAccount)
+	}
+}
+
+// ListDeliveriesHandler handles request for listing the delivery attempts of the customer's
+// notifications, filtered like the admin event list
+func (s *Server) ListDeliveriesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseEventFilter(r)
+		if err != nil {
+			render.Render(w, r, ErrBadRequest(err))
+			return
+		}
+
+		selectedCustomer, err := s.accountCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		filter.CustomerID = selectedCustomer.ID
+
+		deliveries, err := s.DeliveryRepository.Find(r.Context(), filter)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.JSON(w, r, deliveries)
+	}
+}
+
+// CreateSubAccountRequest is a struct for create sub-account endpoint's request body
+type CreateSubAccountRequest struct {
+	Email           string `json:"email" validate:"required,email,max=255"`
+	CallbackURL     string `json:"callback_url" validate:"omitempty,url,max=2048"`
+	ForwardToParent bool   `json:"forward_to_parent"`
+}
+
+// UpdateSubAccountRequest is a struct for update sub-account endpoint's request body
+type UpdateSubAccountRequest struct {
+	ForwardToParent *bool `json:"forward_to_parent" validate:"required"`
+}
@@ -0,0 +1,230 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/event"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestServer_SubAccounts(t *testing.T) {
+	server := setupTokenServer(t)
+	router := server.Router()
+	mailer := server.Mailer.(*MockMailer)
+
+	callbackPaths := make(chan string, 4)
+	mockCustomerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callbackPaths <- r.URL.Path
+		w.Write([]byte(`OK`))
+	}))
+	defer mockCustomerServer.Close()
+
+	if err := mustRegisterVerified(server, "outsider@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, url, body, accessToken, organizationID string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		if organizationID != "" {
+			req.Header.Set(OrganizationHeader, organizationID)
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	accessToken := func(email string) string {
+		rr := httptest.NewRecorder()
+		body := `{"email": "` + email + `", "password": "password", "tokens": true}`
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/login", bytes.NewBufferString(body)))
+		var tokens TokenPair
+		if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil {
+			t.Fatal(err)
+		}
+		return tokens.AccessToken
+	}
+
+	parent := accessToken("example@example.com")
+	outsider := accessToken("outsider@example.com")
+
+	rr := request("POST", "/callback_url", `{"callback_url": "`+mockCustomerServer.URL+`/parent"}`, parent, "")
+	if got, want := rr.Code, http.StatusOK; got != want {
+		t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+	}
+
+	var subAccount customer.Customer
+	t.Run("Create sub-account", func(t *testing.T) {
+		body := `{"email": "shop@example.com", "callback_url": "https://example.com/shop", "forward_to_parent": true}`
+		rr := request("POST", "/sub_accounts", body, parent, "")
+		if got, want := rr.Code, http.StatusCreated; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&subAccount); err != nil {
+			t.Fatal(err)
+		}
+		if subAccount.ParentID == nil || *subAccount.ParentID != 1 || !subAccount.ForwardToParent {
+			t.Errorf("Want sub-account of customer 1 forwarding its events, got %+v", subAccount)
+		}
+	})
+	subAccountID := strconv.FormatUint(subAccount.ID, 10)
+
+	t.Run("Sub-accounts can't have sub-accounts", func(t *testing.T) {
+		rr := request("POST", "/sub_accounts", `{"email": "nested@example.com"}`, parent, subAccountID)
+		if got, want := rr.Code, http.StatusBadRequest; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("List sub-accounts", func(t *testing.T) {
+		rr := request("GET", "/sub_accounts", "", parent, "")
+		var subAccounts []*customer.Customer
+		if err := json.NewDecoder(rr.Body).Decode(&subAccounts); err != nil {
+			t.Fatal(err)
+		}
+		if len(subAccounts) != 1 || subAccounts[0].Email != "shop@example.com" {
+			t.Errorf("Want sub-account shop@example.com, got %+v", subAccounts)
+		}
+
+		rr = request("GET", "/sub_accounts", "", outsider, "")
+		subAccounts = nil
+		if err := json.NewDecoder(rr.Body).Decode(&subAccounts); err != nil {
+			t.Fatal(err)
+		}
+		if len(subAccounts) != 0 {
+			t.Errorf("Want no sub-accounts of another customer, got %+v", subAccounts)
+		}
+	})
+
+	t.Run("Parent sets callback url of the unverified sub-account", func(t *testing.T) {
+		rr := request("POST", "/callback_url", `{"callback_url": "`+mockCustomerServer.URL+`/shop"}`, parent, subAccountID)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		selectedCustomer, err := server.CustomerRepository.FindByID(context.Background(), subAccount.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := selectedCustomer.Callback.CallbackURL, mockCustomerServer.URL+"/shop"; got != want {
+			t.Errorf("Want callback url of the sub-account %s, got %s", want, got)
+		}
+	})
+
+	t.Run("Events of the unverified sub-account are forwarded to the parent", func(t *testing.T) {
+		alfamartRequest := &AlfamartPaymentCallbackRequest{
+			PaymentID:   "123123122",
+			PaymentCode: "XYZ122",
+			PaidAt:      time.Now(),
+			ExternalID:  "order-122",
+			CustomerID:  subAccount.ID,
+		}
+		if _, err := sendRequest(server.AlfamartPaymentCallbackHandler(), "POST", "/alfamart_payment_callback", alfamartRequest, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case path := <-callbackPaths:
+			if path != "/parent" {
+				t.Errorf("Want event delivered to the parent only, got %s", path)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Want event delivered to the parent")
+		}
+	})
+
+	t.Run("Sub-account verifies its email", func(t *testing.T) {
+		if subAccount.EmailVerified() {
+			t.Errorf("Want sub-account with unverified email")
+		}
+		mails := mailer.Mails()
+		if len(mails) == 0 || mails[len(mails)-1].To != "shop@example.com" {
+			t.Fatalf("Want verification email to shop@example.com, got %+v", mails)
+		}
+
+		token, err := server.EmailVerifier.Token(&subAccount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := request("POST", "/verify-email", `{"token": "`+token+`"}`, "", "")
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Outsider can't manage the sub-account", func(t *testing.T) {
+		rr := request("GET", "/deliveries", "", outsider, subAccountID)
+		if got, want := rr.Code, http.StatusNotFound; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		rr = request("PATCH", "/sub_accounts/"+subAccountID, `{"forward_to_parent": false}`, outsider, "")
+		if got, want := rr.Code, http.StatusNotFound; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Events are forwarded to the parent", func(t *testing.T) {
+		alfamartRequest := &AlfamartPaymentCallbackRequest{
+			PaymentID:   "123123123",
+			PaymentCode: "XYZ123",
+			PaidAt:      time.Now(),
+			ExternalID:  "order-123",
+			CustomerID:  subAccount.ID,
+		}
+		if _, err := sendRequest(server.AlfamartPaymentCallbackHandler(), "POST", "/alfamart_payment_callback", alfamartRequest, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		paths := map[string]bool{}
+		for len(paths) < 2 {
+			select {
+			case path := <-callbackPaths:
+				paths[path] = true
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Want event delivered to sub-account and parent, got %v", paths)
+			}
+		}
+		if !paths["/shop"] || !paths["/parent"] {
+			t.Errorf("Want event delivered to sub-account and parent, got %v", paths)
+		}
+	})
+
+	t.Run("Parent views deliveries of the sub-account", func(t *testing.T) {
+		var deliveries []*event.Delivery
+		for deadline := time.Now().Add(5 * time.Second); len(deliveries) == 0 && time.Now().Before(deadline); {
+			rr := request("GET", "/deliveries", "", parent, subAccountID)
+			if got, want := rr.Code, http.StatusOK; got != want {
+				t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&deliveries); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(deliveries) != 1 || deliveries[0].CustomerID != subAccount.ID {
+			t.Errorf("Want one delivery of the sub-account, got %+v", deliveries)
+		}
+	})
+
+	t.Run("Stop forwarding", func(t *testing.T) {
+		rr := request("PATCH", "/sub_accounts/"+subAccountID, `{"forward_to_parent": false}`, parent, "")
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		updated, err := server.CustomerRepository.FindByID(context.Background(), subAccount.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.ForwardToParent {
+			t.Errorf("Want sub-account not forwarding its events")
+		}
+	})
+}
@@ -0,0 +1,398 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/golang-jwt/jwt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/datastore"
+)
+
+var (
+	// errInvalidToken is returned for tokens that are malformed, expired or revoked
+	errInvalidToken = errors.New("invalid token")
+	// errTokensDisabled is returned by token endpoints when JWT_SECRET is not set
+	errTokensDisabled = errors.New("token login is disabled")
+	// errRefreshTokenReused is returned when a rotated refresh token is used again, the
+	// token's family is revoked since the token may be stolen
+	errRefreshTokenReused = errors.New("refresh token is reused, every token of its login is revoked")
+)
+
+// AccessClaims are the claims of an access token, Family identifies the login the token was
+// issued for and is shared by every token refreshed from that login
+type AccessClaims struct {
+	jwt.StandardClaims
+	Family    string `json:"fam"`
+	TwoFactor bool   `json:"tfa,omitempty"`
+}
+
+// CustomerID returns id of the customer the token is issued to
+func (c *AccessClaims) CustomerID() (uint64, error) {
+	return strconv.ParseUint(c.Subject, 10, 64)
+}
+
+// TokenPair is a struct for token endpoints' response body
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// TokenIssuer issues signed short-lived access tokens and rotating refresh tokens. Refresh
+// tokens and revoked logins are kept in redis so every replica shares them.
+type TokenIssuer struct {
+	Pool       *redis.Pool
+	Secret     []byte
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// NewTokenIssuer returns new token issuer signing access tokens with secret
+func NewTokenIssuer(pool *redis.Pool, secret []byte) *TokenIssuer {
+	return &TokenIssuer{
+		Pool:       pool,
+		Secret:     secret,
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: 30 * 24 * time.Hour,
+	}
+}
+
+// NewTokenIssuerFromEnv returns new token issuer configured by environment variables, it
+// returns nil when JWT_SECRET is not set
+func NewTokenIssuerFromEnv(pool *redis.Pool) *TokenIssuer {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return nil
+	}
+
+	issuer := NewTokenIssuer(pool, []byte(secret))
+	if accessTTL, err := time.ParseDuration(os.Getenv("JWT_ACCESS_TTL")); err == nil {
+		issuer.AccessTTL = accessTTL
+	}
+	if refreshTTL, err := time.ParseDuration(os.Getenv("JWT_REFRESH_TTL")); err == nil {
+		issuer.RefreshTTL = refreshTTL
+	}
+	return issuer
+}
+
+// Issue returns tokens of a new login of the customer, twoFactor tells whether the login
+// passed the second factor
+func (t *TokenIssuer) Issue(ctx context.Context, c *customer.Customer, twoFactor bool) (*TokenPair, error) {
+	family, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	return t.issue(c.ID, family, twoFactor)
+}
+
+// useRefreshToken counts uses of a stored refresh token, it returns -1 when the token
+// doesn't exist so expired tokens aren't recreated
+var useRefreshToken = redis.NewScript(1, `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+return redis.call("HINCRBY", KEYS[1], "uses", 1)
+`)
+
+// Refresh exchanges the refresh token for new tokens of the same login, the refresh token
+// can only be used once
+func (t *TokenIssuer) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	conn := t.Pool.Get()
+	defer conn.Close()
+
+	key := refreshTokenKey(refreshToken)
+	values, err := redis.StringMap(conn.Do("HGETALL", key))
+	if err != nil {
+		return nil, err
+	}
+	customerID, err := strconv.ParseUint(values["customer_id"], 10, 64)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	family := values["family"]
+
+	revoked, err := t.isRevoked(conn, family)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errInvalidToken
+	}
+
+	// Only the first use of the token sees 1, concurrent refreshes with the same token are
+	// reuse too
+	uses, err := redis.Int(useRefreshToken.Do(conn, key))
+	if err != nil {
+		return nil, err
+	}
+	if uses < 0 {
+		return nil, errInvalidToken
+	}
+	if uses > 1 {
+		if err := t.Revoke(ctx, family); err != nil {
+			return nil, err
+		}
+		return nil, errRefreshTokenReused
+	}
+
+	return t.issue(customerID, family, values["two_factor"] == "1")
+}
+
+// Verify returns claims of the access token when it is validly signed, not expired and its
+// login isn't revoked
+func (t *TokenIssuer) Verify(ctx context.Context, accessToken string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return t.Secret, nil
+	})
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	conn := t.Pool.Get()
+	defer conn.Close()
+
+	revoked, err := t.isRevoked(conn, claims.Family)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errInvalidToken
+	}
+	return claims, nil
+}
+
+// Revoke revokes every access and refresh token of the login
+func (t *TokenIssuer) Revoke(ctx context.Context, family string) error {
+	conn := t.Pool.Get()
+	defer conn.Close()
+
+	// Refresh tokens of the login expire before the revocation does
+	_, err := conn.Do("SET", revokedFamilyKey(family), 1, "PX", t.RefreshTTL.Milliseconds())
+	return err
+}
+
+// RevokeRefreshToken revokes every token of the login the refresh token belongs to
+func (t *TokenIssuer) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	conn := t.Pool.Get()
+	family, err := redis.String(conn.Do("HGET", refreshTokenKey(refreshToken), "family"))
+	conn.Close()
+	if err == redis.ErrNil {
+		return errInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+
+	return t.Revoke(ctx, family)
+}
+
+// RevokeCustomer revokes every access and refresh token of the customer except the tokens of
+// the keepFamily login
+func (t *TokenIssuer) RevokeCustomer(ctx context.Context, customerID uint64, keepFamily string) error {
+	conn := t.Pool.Get()
+	families, err := redis.Strings(conn.Do("SMEMBERS", customerLoginsKey(customerID)))
+	conn.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, family := range families {
+		if family == keepFamily {
+			continue
+		}
+		if err := t.Revoke(ctx, family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *TokenIssuer) issue(customerID uint64, family string, twoFactor bool) (*TokenPair, error) {
+	now := time.Now()
+	jti, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &AccessClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   strconv.FormatUint(customerID, 10),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(t.AccessTTL).Unix(),
+		},
+		Family:    family,
+		TwoFactor: twoFactor,
+	}).SignedString(t.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	conn := t.Pool.Get()
+	defer conn.Close()
+
+	key := refreshTokenKey(refreshToken)
+	if err := conn.Send("MULTI"); err != nil {
+		return nil, err
+	}
+	conn.Send("HSET", key, "customer_id", customerID, "family", family, "two_factor", twoFactor, "uses", 0)
+	conn.Send("PEXPIRE", key, t.RefreshTTL.Milliseconds())
+	conn.Send("SADD", customerLoginsKey(customerID), family)
+	conn.Send("PEXPIRE", customerLoginsKey(customerID), t.RefreshTTL.Milliseconds())
+	if _, err := conn.Do("EXEC"); err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(t.AccessTTL.Seconds()),
+	}, nil
+}
+
+func (t *TokenIssuer) isRevoked(conn redis.Conn, family string) (bool, error) {
+	return redis.Bool(conn.Do("EXISTS", revokedFamilyKey(family)))
+}
+
+func refreshTokenKey(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+	return "refresh_token:" + hex.EncodeToString(hash[:])
+}
+
+func customerLoginsKey(customerID uint64) string {
+	return "customer_logins:" + strconv.FormatUint(customerID, 10)
+}
+
+func revokedFamilyKey(family string) string {
+	return "revoked_login:" + family
+}
+
+func randomToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// authenticateAccessToken lets requests with valid access token through as the customer the
+// token is issued to
+func (s *Server) authenticateAccessToken(next http.Handler, w http.ResponseWriter, r *http.Request, accessToken string) {
+	if s.Tokens == nil {
+		render.Render(w, r, ErrUnauthorized(fmt.Errorf("invalid api key")))
+		return
+	}
+
+	claims, err := s.Tokens.Verify(r.Context(), accessToken)
+	if err != nil {
+		render.Render(w, r, ErrFrom(err))
+		return
+	}
+	customerID, err := claims.CustomerID()
+	if err != nil {
+		render.Render(w, r, ErrUnauthorized(errInvalidToken))
+		return
+	}
+
+	tokenOwner, err := s.CustomerRepository.FindByID(r.Context(), customerID)
+	if errors.Is(err, datastore.ErrNotFound) {
+		render.Render(w, r, ErrUnauthorized(errInvalidToken))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), customerContextKey, tokenOwner)
+	ctx = context.WithValue(ctx, accessClaimsContextKey, claims)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (s *Server) issueTokens(w http.ResponseWriter, r *http.Request, c *customer.Customer, twoFactor bool) {
+	if s.Tokens == nil {
+		render.Render(w, r, ErrBadRequest(errTokensDisabled))
+		return
+	}
+
+	tokens, err := s.Tokens.Issue(r.Context(), c, twoFactor)
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+
+	render.JSON(w, r, tokens)
+}
+
+// RefreshTokenHandler handles request for exchanging a refresh token for new tokens
+func (s *Server) RefreshTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshTokenRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		if s.Tokens == nil {
+			render.Render(w, r, ErrBadRequest(errTokensDisabled))
+			return
+		}
+
+		tokens, err := s.Tokens.Refresh(r.Context(), req.RefreshToken)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.JSON(w, r, tokens)
+	}
+}
+
+// RevokeTokenHandler handles request for revoking every token of the login the refresh
+// token belongs to
+func (s *Server) RevokeTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshTokenRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		if s.Tokens == nil {
+			render.Render(w, r, ErrBadRequest(errTokensDisabled))
+			return
+		}
+
+		if err := s.Tokens.RevokeRefreshToken(r.Context(), req.RefreshToken); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RefreshTokenRequest is a struct for refresh and revoke token endpoints' request body
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required,max=128"`
+}
@@ -0,0 +1,113 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func newMockRedisPool(t *testing.T) *redis.Pool {
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(redisServer.Close)
+
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) { return redis.Dial("tcp", redisServer.Addr()) },
+	}
+}
+
+func setupTokenServer(t *testing.T) *Server {
+	pool := newMockRedisPool(t)
+	server := setupMockServer()
+	server.Tokens = NewTokenIssuer(pool, []byte("secret"))
+	server.PasswordResets = NewPasswordResetter(pool)
+	server.Invitations = NewInvitations(pool)
+
+	if err := mustRegisterVerified(server, "example@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestServer_Tokens(t *testing.T) {
+	server := setupTokenServer(t)
+	router := server.Router()
+
+	request := func(method, url, body, accessToken string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		if accessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	decodeTokens := func(t *testing.T, rr *httptest.ResponseRecorder) *TokenPair {
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		var tokens TokenPair
+		if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil {
+			t.Fatal(err)
+		}
+		return &tokens
+	}
+
+	login := decodeTokens(t, request("POST", "/login", `{"email": "example@example.com", "password": "password", "tokens": true}`, ""))
+
+	t.Run("Authenticate with access token", func(t *testing.T) {
+		if got, want := request("GET", "/api_keys", "", login.AccessToken).Code, http.StatusOK; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Invalid access token", func(t *testing.T) {
+		if got, want := request("GET", "/api_keys", "", login.AccessToken+"x").Code, http.StatusUnauthorized; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	refreshed := decodeTokens(t, request("POST", "/token/refresh", `{"refresh_token": "`+login.RefreshToken+`"}`, ""))
+
+	t.Run("Reused refresh token revokes the login", func(t *testing.T) {
+		if got, want := request("POST", "/token/refresh", `{"refresh_token": "`+login.RefreshToken+`"}`, "").Code, http.StatusUnauthorized; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got, want := request("POST", "/token/refresh", `{"refresh_token": "`+refreshed.RefreshToken+`"}`, "").Code, http.StatusUnauthorized; got != want {
+			t.Errorf("refreshed token isn't revoked: got %v, want %v", got, want)
+		}
+		if got, want := request("GET", "/api_keys", "", refreshed.AccessToken).Code, http.StatusUnauthorized; got != want {
+			t.Errorf("access token isn't revoked: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		tokens := decodeTokens(t, request("POST", "/login", `{"email": "example@example.com", "password": "password", "tokens": true}`, ""))
+
+		if got, want := request("POST", "/token/revoke", `{"refresh_token": "`+tokens.RefreshToken+`"}`, "").Code, http.StatusNoContent; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got, want := request("GET", "/api_keys", "", tokens.AccessToken).Code, http.StatusUnauthorized; got != want {
+			t.Errorf("access token isn't revoked: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		tokens := decodeTokens(t, request("POST", "/login", `{"email": "example@example.com", "password": "password", "tokens": true}`, ""))
+
+		if got, want := request("POST", "/logout", "", tokens.AccessToken).Code, http.StatusNoContent; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got, want := request("POST", "/token/refresh", `{"refresh_token": "`+tokens.RefreshToken+`"}`, "").Code, http.StatusUnauthorized; got != want {
+			t.Errorf("refresh token isn't revoked: got %v, want %v", got, want)
+		}
+	})
+}
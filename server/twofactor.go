@@ -0,0 +1,411 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abraithwaite/jeff"
+	"github.com/go-chi/render"
+	"github.com/golang-jwt/jwt"
+	"github.com/ngavinsir/notification-service/customer"
+	"github.com/ngavinsir/notification-service/datastore"
+	"github.com/ngavinsir/notification-service/util/totp"
+)
+
+// loginTwoFactorAudience marks tokens that can only finish a login with the second factor
+const loginTwoFactorAudience = "login_2fa"
+
+// recoveryCodeCount is the number of recovery codes given on 2fa enrolment
+const recoveryCodeCount = 10
+
+// TwoFactorCodeHeader carries a 2fa code for requests whose login didn't pass 2fa, e.g. by
+// api key
+const TwoFactorCodeHeader = "X-2FA-Code"
+
+var (
+	// errInvalidTwoFactorCode is returned for 2fa codes that are wrong, expired or already used
+	errInvalidTwoFactorCode = errors.New("invalid 2fa code")
+	// errTwoFactorRequired is returned when a customer with 2fa changes callback settings
+	// without passing 2fa
+	errTwoFactorRequired = fmt.Errorf("2fa is required, log in with 2fa or send a 2fa code in %s header", TwoFactorCodeHeader)
+)
+
+// loginTwoFactorClaims are the claims of the token given after the password step of a login
+// of a customer with 2fa
+type loginTwoFactorClaims struct {
+	jwt.StandardClaims
+	Tokens bool `json:"tokens,omitempty"`
+}
+
+// TwoFactor holds 2fa settings
+type TwoFactor struct {
+	// Issuer names the service in authenticator apps
+	Issuer string
+	// Secret signs tokens of logins waiting for the second factor
+	Secret   []byte
+	LoginTTL time.Duration
+}
+
+// NewTwoFactor returns new 2fa settings signing login tokens with secret
+func NewTwoFactor(secret []byte) *TwoFactor {
+	return &TwoFactor{
+		Issuer:   "Notification Service",
+		Secret:   secret,
+		LoginTTL: 5 * time.Minute,
+	}
+}
+
+// NewTwoFactorFromEnv returns new 2fa settings configured by environment variables
+func NewTwoFactorFromEnv() *TwoFactor {
+	twoFactor := NewTwoFactor(signingSecretFromEnv("TWO_FACTOR_SECRET"))
+	if issuer := os.Getenv("TWO_FACTOR_ISSUER"); issuer != "" {
+		twoFactor.Issuer = issuer
+	}
+	return twoFactor
+}
+
+// LoginToken returns token finishing the login of the customer with the second factor,
+// tokens tells whether the login issues tokens instead of starting a session
+func (t *TwoFactor) LoginToken(c *customer.Customer, tokens bool) (string, error) {
+	now := time.Now()
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, &loginTwoFactorClaims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  loginTwoFactorAudience,
+			Subject:   strconv.FormatUint(c.ID, 10),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(t.LoginTTL).Unix(),
+		},
+		Tokens: tokens,
+	}).SignedString(t.Secret)
+}
+
+// VerifyLoginToken returns the customer id and whether tokens are issued of the login when
+// the token is validly signed and not expired
+func (t *TwoFactor) VerifyLoginToken(token string) (uint64, bool, error) {
+	claims := &loginTwoFactorClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return t.Secret, nil
+	})
+	if err != nil || !claims.VerifyAudience(loginTwoFactorAudience, true) {
+		return 0, false, errInvalidToken
+	}
+
+	customerID, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return 0, false, errInvalidToken
+	}
+	return customerID, claims.Tokens, nil
+}
+
+// checkSecondFactor accepts a current totp code that wasn't used before or an unused
+// recovery code of the customer, the used code is stored so it can't be used again
+func (s *Server) checkSecondFactor(ctx context.Context, c *customer.Customer, code string) error {
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(c.TOTPSecret, code, time.Now()); ok {
+		if step <= c.TOTPLastStep {
+			return errInvalidTwoFactorCode
+		}
+		c.TOTPLastStep = step
+	} else if !c.RecoveryCodes.Use(code) {
+		return errInvalidTwoFactorCode
+	}
+
+	return s.CustomerRepository.Save(ctx, c)
+}
+
+// passedTwoFactor reports whether the request's login passed the second factor
+func passedTwoFactor(r *http.Request) bool {
+	if claims, ok := r.Context().Value(accessClaimsContextKey).(*AccessClaims); ok {
+		return claims.TwoFactor
+	}
+
+	var meta SessionMeta
+	// Requests by api key have no session and sessions started before 2fa have no meta
+	json.Unmarshal(jeff.ActiveSession(r.Context()).Meta, &meta)
+	return meta.TwoFactor
+}
+
+// requireTwoFactor returns errTwoFactorRequired when the customer has 2fa but the request
+// neither passed 2fa on login nor carries a valid code in TwoFactorCodeHeader, it returns
+// RateLimitError while the customer is locked out by LoginGuard
+func (s *Server) requireTwoFactor(r *http.Request, c *customer.Customer) error {
+	if !c.TwoFactorEnabled() || passedTwoFactor(r) {
+		return nil
+	}
+
+	code := r.Header.Get(TwoFactorCodeHeader)
+	if code == "" {
+		return errTwoFactorRequired
+	}
+	// Wrong codes count as failed logins, so holders of a session, token or api key can't
+	// guess the code
+	if err := s.checkLogin(r, c.Email); err != nil {
+		return err
+	}
+	err := s.checkSecondFactor(r.Context(), c, code)
+	if errors.Is(err, errInvalidTwoFactorCode) {
+		s.failLogin(r, c.Email, c)
+		return errTwoFactorRequired
+	}
+	return err
+}
+
+// invalidCodeError is returned by 2fa endpoints for wrong codes of logged in customers,
+// unlike errInvalidTwoFactorCode it doesn't log the customer out
+var invalidCodeError = &ValidationError{Fields: []FieldError{{
+	Field:   "code",
+	Message: "is invalid",
+}}}
+
+// challengeTwoFactor responds to a login with correct password of a customer with 2fa with
+// the token finishing the login with the second factor
+func (s *Server) challengeTwoFactor(w http.ResponseWriter, r *http.Request, c *customer.Customer, tokens bool) {
+	loginToken, err := s.TwoFactor.LoginToken(c, tokens)
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, &TwoFactorChallenge{
+		TwoFactorRequired: true,
+		LoginToken:        loginToken,
+		ExpiresIn:         int(s.TwoFactor.LoginTTL.Seconds()),
+	})
+}
+
+// LoginTwoFactorHandler handles request for finishing a login with the second factor
+func (s *Server) LoginTwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req LoginTwoFactorRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		customerID, tokens, err := s.TwoFactor.VerifyLoginToken(req.LoginToken)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		selectedCustomer, err := s.CustomerRepository.FindByID(r.Context(), customerID)
+		if errors.Is(err, datastore.ErrNotFound) {
+			render.Render(w, r, ErrUnauthorized(errInvalidToken))
+			return
+		}
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		if !selectedCustomer.TwoFactorEnabled() {
+			render.Render(w, r, ErrUnauthorized(errInvalidToken))
+			return
+		}
+		// Wrong codes count as failed logins too, the password step doesn't reset them
+		if err := s.checkLogin(r, selectedCustomer.Email); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		err = s.checkSecondFactor(r.Context(), selectedCustomer, req.Code)
+		if errors.Is(err, errInvalidTwoFactorCode) {
+			s.failLogin(r, selectedCustomer.Email, selectedCustomer)
+		}
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		s.startLogin(w, r, selectedCustomer, tokens, true)
+	}
+}
+
+// EnrollTwoFactorHandler handles request for starting 2fa enrolment, 2fa is enabled once a
+// code of the returned secret is confirmed
+func (s *Server) EnrollTwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req EnrollTwoFactorRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		selectedCustomer, err := s.credentialsOwner(r, req.CurrentPassword)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if selectedCustomer.TwoFactorEnabled() {
+			render.Render(w, r, ErrConflict(fmt.Errorf("2fa is already enabled")))
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		selectedCustomer.TOTPSecret = secret
+		if err := s.CustomerRepository.Save(r.Context(), selectedCustomer); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		render.JSON(w, r, &EnrollTwoFactorResponse{
+			Secret:          secret,
+			ProvisioningURI: totp.URI(s.TwoFactor.Issuer, selectedCustomer.Email, secret),
+		})
+	}
+}
+
+// ConfirmTwoFactorHandler handles request for enabling 2fa with a code of the enrolled
+// secret, the recovery codes are only returned in this response. Every other session and
+// login of the customer is revoked.
+func (s *Server) ConfirmTwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TwoFactorCodeRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		selectedCustomer, err := s.loggedInCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if selectedCustomer.TwoFactorEnabled() {
+			render.Render(w, r, ErrConflict(fmt.Errorf("2fa is already enabled")))
+			return
+		}
+		if selectedCustomer.TOTPSecret == "" {
+			render.Render(w, r, ErrBadRequest(fmt.Errorf("2fa enrolment isn't started")))
+			return
+		}
+
+		step, ok := totp.Validate(selectedCustomer.TOTPSecret, strings.TrimSpace(req.Code), time.Now())
+		if !ok {
+			render.Render(w, r, ErrBadRequest(invalidCodeError))
+			return
+		}
+
+		codes, hashes, err := customer.NewRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+		now := time.Now()
+		selectedCustomer.TwoFactorEnabledAt = &now
+		selectedCustomer.TOTPLastStep = step
+		selectedCustomer.RecoveryCodes = hashes
+		if err := s.CustomerRepository.Save(r.Context(), selectedCustomer); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		if err := s.renewLogin(w, r, selectedCustomer, selectedCustomer.Email, true); err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+
+		render.JSON(w, r, &ConfirmTwoFactorResponse{RecoveryCodes: codes})
+	}
+}
+
+// DisableTwoFactorHandler handles request for disabling 2fa
+func (s *Server) DisableTwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req DisableTwoFactorRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		selectedCustomer, err := s.credentialsOwner(r, req.CurrentPassword)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if !selectedCustomer.TwoFactorEnabled() {
+			render.Render(w, r, ErrBadRequest(fmt.Errorf("2fa isn't enabled")))
+			return
+		}
+
+		// Wrong codes count as failed logins, so holders of a session and the password can't
+		// guess the code
+		err = s.checkSecondFactor(r.Context(), selectedCustomer, req.Code)
+		if errors.Is(err, errInvalidTwoFactorCode) {
+			s.failLogin(r, selectedCustomer.Email, selectedCustomer)
+			render.Render(w, r, ErrBadRequest(invalidCodeError))
+			return
+		}
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		selectedCustomer.TOTPSecret = ""
+		selectedCustomer.TOTPLastStep = 0
+		selectedCustomer.TwoFactorEnabledAt = nil
+		selectedCustomer.RecoveryCodes = nil
+		if err := s.CustomerRepository.Save(r.Context(), selectedCustomer); err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// TwoFactorChallenge is a struct for login endpoint's response body when the customer has 2fa
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	LoginToken        string `json:"login_token"`
+	ExpiresIn         int    `json:"expires_in"`
+}
+
+// LoginTwoFactorRequest is a struct for 2fa login endpoint's request body, Code is a totp code
+// or a recovery code
+type LoginTwoFactorRequest struct {
+	LoginToken string `json:"login_token" validate:"required,max=1024"`
+	Code       string `json:"code" validate:"required,max=32"`
+}
+
+// EnrollTwoFactorRequest is a struct for 2fa enrolment endpoint's request body
+type EnrollTwoFactorRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+}
+
+// EnrollTwoFactorResponse is a struct for 2fa enrolment endpoint's response body, authenticator
+// apps enrol ProvisioningURI by scanning it as QR code
+type EnrollTwoFactorResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// TwoFactorCodeRequest is a struct for confirm 2fa endpoint's request body
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+// ConfirmTwoFactorResponse is a struct for confirm 2fa endpoint's response body
+type ConfirmTwoFactorResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// DisableTwoFactorRequest is a struct for disable 2fa endpoint's request body
+type DisableTwoFactorRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	Code            string `json:"code" validate:"required,max=32"`
+}
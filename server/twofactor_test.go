@@ -0,0 +1,206 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/ngavinsir/notification-service/server"
+	"github.com/ngavinsir/notification-service/util/totp"
+)
+
+func TestServer_TwoFactor(t *testing.T) {
+	server := setupTokenServer(t)
+	router := server.Router()
+
+	request := func(method, url, body string, headers map[string]string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(t *testing.T, rr *httptest.ResponseRecorder, wantStatus int, v interface{}) {
+		if got, want := rr.Code, wantStatus; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v, body: %s", got, want, rr.Body)
+		}
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	code := func(secret string, step int64) string {
+		code, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	const loginBody = `{"email": "example@example.com", "password": "password"}`
+
+	session := request("POST", "/login", loginBody, nil, nil).Result().Cookies()
+	step := totp.Step(time.Now())
+
+	var enrolment EnrollTwoFactorResponse
+	t.Run("Enroll", func(t *testing.T) {
+		if got, want := request("POST", "/2fa/enroll", `{"current_password": "wrong"}`, nil, session).Code, http.StatusBadRequest; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		decode(t, request("POST", "/2fa/enroll", `{"current_password": "password"}`, nil, session), http.StatusOK, &enrolment)
+		if !strings.HasPrefix(enrolment.ProvisioningURI, "otpauth://totp/") || !strings.Contains(enrolment.ProvisioningURI, enrolment.Secret) {
+			t.Errorf("Want provisioning uri of the secret, got %s", enrolment.ProvisioningURI)
+		}
+	})
+
+	var recoveryCodes []string
+	t.Run("Confirm", func(t *testing.T) {
+		if got, want := request("POST", "/2fa/confirm", `{"code": "000000x"}`, nil, session).Code, http.StatusBadRequest; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		rr := request("POST", "/2fa/confirm", `{"code": "`+code(enrolment.Secret, step)+`"}`, nil, session)
+		var confirmed ConfirmTwoFactorResponse
+		decode(t, rr, http.StatusOK, &confirmed)
+		if len(confirmed.RecoveryCodes) != 10 {
+			t.Errorf("Want 10 recovery codes, got %v", confirmed.RecoveryCodes)
+		}
+		recoveryCodes = confirmed.RecoveryCodes
+
+		session = rr.Result().Cookies()
+		rr = request("POST", "/callback_url", `{"callback_url": "http://www.example.com"}`, nil, session)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Errorf("session passed 2fa can't set callback: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Login needs second factor", func(t *testing.T) {
+		var challenge TwoFactorChallenge
+		rr := request("POST", "/login", loginBody, nil, nil)
+		if len(rr.Result().Cookies()) != 0 {
+			t.Errorf("Want no session before second factor")
+		}
+		decode(t, rr, http.StatusAccepted, &challenge)
+
+		used := `{"login_token": "` + challenge.LoginToken + `", "code": "` + code(enrolment.Secret, step) + `"}`
+		if got, want := request("POST", "/login/2fa", used, nil, nil).Code, http.StatusUnauthorized; got != want {
+			t.Errorf("used code is accepted: got %v, want %v", got, want)
+		}
+
+		next := `{"login_token": "` + challenge.LoginToken + `", "code": "` + code(enrolment.Secret, step+1) + `"}`
+		rr = request("POST", "/login/2fa", next, nil, nil)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got, want := request("GET", "/sessions", "", nil, rr.Result().Cookies()).Code, http.StatusOK; got != want {
+			t.Errorf("session isn't started: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Login with recovery code", func(t *testing.T) {
+		var challenge TwoFactorChallenge
+		decode(t, request("POST", "/login", loginBody, nil, nil), http.StatusAccepted, &challenge)
+
+		body := `{"login_token": "` + challenge.LoginToken + `", "code": "` + strings.ToUpper(recoveryCodes[0]) + `"}`
+		if got, want := request("POST", "/login/2fa", body, nil, nil).Code, http.StatusOK; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got, want := request("POST", "/login/2fa", body, nil, nil).Code, http.StatusUnauthorized; got != want {
+			t.Errorf("used recovery code is accepted: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("API key needs 2fa code to set callback", func(t *testing.T) {
+		var created CreateAPIKeyResponse
+		decode(t, request("POST", "/api_keys", `{"name": "backend", "scopes": ["endpoints:write"]}`, nil, session), http.StatusCreated, &created)
+		authorization := map[string]string{"Authorization": "Bearer " + created.Key}
+
+		rr := request("POST", "/callback_url", `{"callback_url": "http://www.example.com"}`, authorization, nil)
+		if got, want := rr.Code, http.StatusForbidden; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		authorization[TwoFactorCodeHeader] = recoveryCodes[1]
+		rr = request("POST", "/callback_url", `{"callback_url": "http://www.example.com"}`, authorization, nil)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("API key needs 2fa code to set callback of a sub-account", func(t *testing.T) {
+		var created CreateAPIKeyResponse
+		decode(t, request("POST", "/api_keys", `{"name": "shops", "scopes": ["sub_accounts:write"]}`, nil, session), http.StatusCreated, &created)
+		authorization := map[string]string{"Authorization": "Bearer " + created.Key}
+
+		body := `{"email": "shop@example.com", "callback_url": "http://www.example.com"}`
+		if got, want := request("POST", "/sub_accounts", body, authorization, nil).Code, http.StatusForbidden; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+
+		authorization[TwoFactorCodeHeader] = recoveryCodes[4]
+		if got, want := request("POST", "/sub_accounts", body, authorization, nil).Code, http.StatusCreated; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Wrong 2fa codes are throttled", func(t *testing.T) {
+		server.LoginGuard = NewLoginGuard(newMockRedisPool(t))
+		server.LoginGuard.DelayAfter = 100
+		server.LoginGuard.MaxFailures = 3
+		defer func() { server.LoginGuard = nil }()
+
+		var created CreateAPIKeyResponse
+		decode(t, request("POST", "/api_keys", `{"name": "guesser", "scopes": ["endpoints:write"]}`, nil, session), http.StatusCreated, &created)
+		authorization := map[string]string{"Authorization": "Bearer " + created.Key, TwoFactorCodeHeader: "000000"}
+
+		for i := 0; i < 3; i++ {
+			rr := request("POST", "/callback_url", `{"callback_url": "http://www.example.com"}`, authorization, nil)
+			if got, want := rr.Code, http.StatusForbidden; got != want {
+				t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+			}
+		}
+
+		authorization[TwoFactorCodeHeader] = recoveryCodes[3]
+		rr := request("POST", "/callback_url", `{"callback_url": "http://www.example.com"}`, authorization, nil)
+		if got, want := rr.Code, http.StatusTooManyRequests; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Wrong codes to disable are throttled", func(t *testing.T) {
+		server.LoginGuard = NewLoginGuard(newMockRedisPool(t))
+		server.LoginGuard.DelayAfter = 100
+		server.LoginGuard.MaxFailures = 3
+		defer func() { server.LoginGuard = nil }()
+
+		body := `{"current_password": "password", "code": "000000"}`
+		for i := 0; i < 3; i++ {
+			if got, want := request("POST", "/2fa/disable", body, nil, session).Code, http.StatusBadRequest; got != want {
+				t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+			}
+		}
+
+		body = `{"current_password": "password", "code": "` + recoveryCodes[2] + `"}`
+		if got, want := request("POST", "/2fa/disable", body, nil, session).Code, http.StatusTooManyRequests; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		body := `{"current_password": "password", "code": "` + recoveryCodes[2] + `"}`
+		if got, want := request("POST", "/2fa/disable", body, nil, session).Code, http.StatusNoContent; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got, want := request("POST", "/login", loginBody, nil, nil).Code, http.StatusOK; got != want {
+			t.Errorf("login still needs second factor: got %v, want %v", got, want)
+		}
+	})
+}
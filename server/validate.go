@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/ngavinsir/notification-service/customer"
+)
+
+// MaxRequestBodySize is the largest request body accepted by the API
+const MaxRequestBodySize = 64 << 10
+
+// errRequestTooLarge is returned when the request body is larger than MaxRequestBodySize
+var errRequestTooLarge = fmt.Errorf("request body is larger than %d bytes", MaxRequestBodySize)
+
+// requestValidator validates request structs by their validate tags, fields are named by
+// their json names
+var requestValidator = newRequestValidator()
+
+func newRequestValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	v.RegisterValidation("scope", func(fl validator.FieldLevel) bool {
+		return customer.AllScopes.Has(fl.Field().String())
+	})
+	return v
+}
+
+// FieldError describes why a field of the request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when the request body doesn't match the request's schema
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+" "+field.Message)
+	}
+	return "invalid request: " + strings.Join(messages, ", ")
+}
+
+// readBody reads the whole request body, failing with errRequestTooLarge when the body is
+// larger than MaxRequestBodySize
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxRequestBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxRequestBodySize {
+		return nil, errRequestTooLarge
+	}
+	return body, nil
+}
+
+// decodeRequest reads the request body into v and validates it
+func decodeRequest(r *http.Request, v interface{}) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	return unmarshalRequest(body, v)
+}
+
+// unmarshalRequest strictly decodes body, which must be a single JSON value without unknown
+// fields, into v and validates it
+func unmarshalRequest(body []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("request body must contain a single JSON value")
+	}
+	return validateRequest(v)
+}
+
+// validateRequest validates v by its validate tags
+func validateRequest(v interface{}) error {
+	err := requestValidator.Struct(v)
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return err
+	}
+
+	validationError := &ValidationError{}
+	for _, fieldError := range fieldErrors {
+		validationError.Fields = append(validationError.Fields, FieldError{
+			Field:   fieldName(fieldError.Namespace()),
+			Message: fieldMessage(fieldError),
+		})
+	}
+	return validationError
+}
+
+// decodeError turns json errors caused by a single field into a validation error
+func decodeError(err error) error {
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case err == io.EOF:
+		return fmt.Errorf("request body is empty")
+	case errors.As(err, &typeError) && typeError.Field != "":
+		return &ValidationError{Fields: []FieldError{{
+			Field:   typeError.Field,
+			Message: "must be " + typeError.Type.Kind().String(),
+		}}}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return &ValidationError{Fields: []FieldError{{
+			Field:   strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`),
+			Message: "is unknown",
+		}}}
+	}
+	return err
+}
+
+// fieldName strips the request struct name from the field's namespace
+func fieldName(namespace string) string {
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+func fieldMessage(fieldError validator.FieldError) string {
+	switch fieldError.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "url":
+		return "must be a valid url"
+	case "max":
+		if fieldError.Kind() == reflect.Slice {
+			return fmt.Sprintf("must have at most %s items", fieldError.Param())
+		}
+		return fmt.Sprintf("must be at most %s characters long", fieldError.Param())
+	case "e164":
+		return "must be a phone number in E.164 format, e.g. +6281234567890"
+	case "timezone":
+		return "must be an IANA time zone, e.g. Asia/Jakarta"
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", fieldError.Param())
+	case "scope":
+		return fmt.Sprintf("must be one of: %s", strings.Join(customer.AllScopes, " "))
+	}
+	return fmt.Sprintf("failed on %s validation", fieldError.Tag())
+}
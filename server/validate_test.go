@@ -0,0 +1,72 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestServer_AlfamartPaymentCallback_Validation(t *testing.T) {
+	server := setupMockServer()
+
+	tests := []struct {
+		name           string
+		body           string
+		wantStatusCode int
+		wantFields     []string
+	}{
+		{
+			name:           "Missing fields",
+			body:           `{"payment_code": "XYZ123", "external_id": "order-123"}`,
+			wantStatusCode: http.StatusBadRequest,
+			wantFields:     []string{"payment_id", "paid_at", "customer_id"},
+		},
+		{
+			name:           "Unknown field",
+			body:           `{"payment_id": "123123123", "payment_code": "XYZ123", "paid_at": "2020-10-17T07:41:33Z", "external_id": "order-123", "customer_id": 1, "status": "paid"}`,
+			wantStatusCode: http.StatusBadRequest,
+			wantFields:     []string{"status"},
+		},
+		{
+			name:           "Wrong type",
+			body:           `{"payment_id": "123123123", "payment_code": "XYZ123", "paid_at": "2020-10-17T07:41:33Z", "external_id": "order-123", "customer_id": "1"}`,
+			wantStatusCode: http.StatusBadRequest,
+			wantFields:     []string{"customer_id"},
+		},
+		{
+			name:           "Body too large",
+			body:           `{"payment_id": "` + strings.Repeat("1", MaxRequestBodySize) + `"}`,
+			wantStatusCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/alfamart_payment_callback", bytes.NewBufferString(test.body))
+			server.AlfamartPaymentCallbackHandler().ServeHTTP(rr, req)
+
+			if got, want := rr.Code, test.wantStatusCode; got != want {
+				t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+			}
+
+			var errResponse ErrResponse
+			if err := json.NewDecoder(rr.Body).Decode(&errResponse); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(errResponse.Details), len(test.wantFields); got != want {
+				t.Fatalf("Want %d invalid fields, got %v", want, errResponse.Details)
+			}
+			for i, field := range test.wantFields {
+				if got := errResponse.Details[i].Field; got != field {
+					t.Errorf("Want invalid field %s, got %s", field, got)
+				}
+			}
+		})
+	}
+}
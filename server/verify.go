@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/golang-jwt/jwt"
+	"github.com/ngavinsir/notification-service/customer"
+)
+
+// verifyEmailAudience marks tokens that can only verify an email
+const verifyEmailAudience = "verify_email"
+
+// errEmailNotVerified is returned when an unverified customer uses an endpoint that needs a
+// verified email
+var errEmailNotVerified = errors.New("email is not verified")
+
+// verificationClaims are the claims of an email verification token, the token is only valid
+// for the email it was sent to
+type verificationClaims struct {
+	jwt.StandardClaims
+	Email string `json:"email"`
+}
+
+// EmailVerifier issues and checks signed expiring email verification tokens
+type EmailVerifier struct {
+	Secret []byte
+	TTL    time.Duration
+	// URL is the page the verification email links to with the token as token query
+	// parameter, the email contains only the token when URL is empty
+	URL string
+}
+
+// NewEmailVerifier returns new email verifier signing tokens with secret
+func NewEmailVerifier(secret []byte) *EmailVerifier {
+	return &EmailVerifier{
+		Secret: secret,
+		TTL:    24 * time.Hour,
+	}
+}
+
+// NewEmailVerifierFromEnv returns new email verifier configured by environment variables
+func NewEmailVerifierFromEnv() *EmailVerifier {
+	verifier := NewEmailVerifier(signingSecretFromEnv("EMAIL_VERIFICATION_SECRET"))
+	if ttl, err := time.ParseDuration(os.Getenv("EMAIL_VERIFICATION_TTL")); err == nil {
+		verifier.TTL = ttl
+	}
+	verifier.URL = os.Getenv("EMAIL_VERIFICATION_URL")
+	return verifier
+}
+
+// signingSecretFromEnv returns the secret in the environment variable, falling back to
+// JWT_SECRET and then to a random secret that is only valid until restart
+func signingSecretFromEnv(name string) []byte {
+	if secret := os.Getenv(name); secret != "" {
+		return []byte(secret)
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	log.Printf("%s is not set, tokens signed with it are only valid until restart", name)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("error when generates %s, error: %v", name, err)
+	}
+	return secret
+}
+
+// Token returns verification token of the customer's current email
+func (v *EmailVerifier) Token(c *customer.Customer) (string, error) {
+	now := time.Now()
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, &verificationClaims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  verifyEmailAudience,
+			Subject:   strconv.FormatUint(c.ID, 10),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(v.TTL).Unix(),
+		},
+		Email: c.Email,
+	}).SignedString(v.Secret)
+}
+
+// Verify returns id and email of the customer the token was sent to when the token is
+// validly signed and not expired
+func (v *EmailVerifier) Verify(token string) (uint64, string, error) {
+	claims := &verificationClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return v.Secret, nil
+	})
+	if err != nil || !claims.VerifyAudience(verifyEmailAudience, true) {
+		return 0, "", errInvalidToken
+	}
+
+	customerID, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return 0, "", errInvalidToken
+	}
+	return customerID, claims.Email, nil
+}
+
+// Mail returns verification email of the customer
+func (v *EmailVerifier) Mail(c *customer.Customer) (*Mail, error) {
+	token, err := v.Token(c)
+	if err != nil {
+		return nil, err
+	}
+
+	action := "Verify your email with this token: " + token
+	if v.URL != "" {
+		action = "Verify your email by opening this link: " + v.URL + "?token=" + url.QueryEscape(token)
+	}
+	return &Mail{
+		To:      c.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n%s\n\nThe verification expires in %s. Payment callbacks won't be delivered until your email is verified.\n",
+			c.Email, action, v.TTL,
+		),
+	}, nil
+}
+
+// sendVerificationEmail emails verification token to the customer
+func (s *Server) sendVerificationEmail(ctx context.Context, c *customer.Customer) error {
+	mail, err := s.EmailVerifier.Mail(c)
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(ctx, mail)
+}
+
+// requireVerifiedEmail returns errEmailNotVerified when the customer hasn't verified its email
+func requireVerifiedEmail(c *customer.Customer) error {
+	if !c.EmailVerified() {
+		return errEmailNotVerified
+	}
+	return nil
+}
+
+// VerifyEmailHandler handles request for verifying the customer's email with the token
+// emailed on register
+func (s *Server) VerifyEmailHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req VerifyEmailRequest
+		if err := decodeRequest(r, &req); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+
+		customerID, email, err := s.EmailVerifier.Verify(req.Token)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+
+		selectedCustomer, err := s.CustomerRepository.FindByID(r.Context(), customerID)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		// The token of a changed email can't verify the current one
+		if selectedCustomer.Email != email {
+			render.Render(w, r, ErrUnauthorized(errInvalidToken))
+			return
+		}
+
+		if !selectedCustomer.EmailVerified() {
+			now := time.Now()
+			selectedCustomer.EmailVerifiedAt = &now
+			if err := s.CustomerRepository.Save(r.Context(), selectedCustomer); err != nil {
+				render.Render(w, r, ErrFrom(err))
+				return
+			}
+		}
+
+		render.JSON(w, r, selectedCustomer)
+	}
+}
+
+// ResendVerificationEmailHandler handles request for emailing a new verification token to
+// the logged in customer, api keys can't send verification emails
+func (s *Server) ResendVerificationEmailHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		selectedCustomer, err := s.loggedInCustomer(r)
+		if err != nil {
+			render.Render(w, r, ErrFrom(err))
+			return
+		}
+		if selectedCustomer.EmailVerified() {
+			render.Render(w, r, ErrBadRequest(fmt.Errorf("email is already verified")))
+			return
+		}
+
+		if err := s.sendVerificationEmail(r.Context(), selectedCustomer); err != nil {
+			render.Render(w, r, ErrInternalServer(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// VerifyEmailRequest is a struct for verify email endpoint's request body
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required,max=1024"`
+}
@@ -0,0 +1,102 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/ngavinsir/notification-service/server"
+)
+
+func TestServer_VerifyEmail(t *testing.T) {
+	server := setupMockServer()
+	router := server.Router()
+	mailer := server.Mailer.(*MockMailer)
+
+	if err := mustRegister(server.RegisterHandler(), "example@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginResponse, err := mustLogin(server.LoginHandler(), "example@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		for _, cookie := range loginResponse.Cookies() {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	var token string
+	t.Run("Verification email is sent on register", func(t *testing.T) {
+		mails := mailer.Mails()
+		if len(mails) != 1 || mails[0].To != "example@example.com" {
+			t.Fatalf("Want 1 verification email to example@example.com, got %+v", mails)
+		}
+
+		const prefix = "Verify your email with this token: "
+		body := mails[0].Body
+		start := strings.Index(body, prefix)
+		if start < 0 {
+			t.Fatalf("Want verification token in email, got %s", body)
+		}
+		token = strings.Fields(body[start+len(prefix):])[0]
+	})
+
+	t.Run("Unverified customer can't set callback", func(t *testing.T) {
+		rr := request("POST", "/callback_url", `{"callback_url": "http://www.example.com"}`)
+		if got, want := rr.Code, http.StatusForbidden; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		expired := NewEmailVerifier([]byte("secret"))
+		expired.TTL = -time.Minute
+		registered, err := server.CustomerRepository.FindByEmail(context.Background(), "example@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expiredToken, err := expired.Token(registered)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, invalid := range []string{token + "x", expiredToken} {
+			rr := request("POST", "/verify-email", `{"token": "`+invalid+`"}`)
+			if got, want := rr.Code, http.StatusUnauthorized; got != want {
+				t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+			}
+		}
+	})
+
+	t.Run("Resend", func(t *testing.T) {
+		if got, want := request("POST", "/verify-email/resend", "").Code, http.StatusAccepted; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got, want := len(mailer.Mails()), 2; got != want {
+			t.Errorf("Want %d verification emails, got %d", want, got)
+		}
+	})
+
+	t.Run("Verify", func(t *testing.T) {
+		if got, want := request("POST", "/verify-email", `{"token": "`+token+`"}`).Code, http.StatusOK; got != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		rr := request("POST", "/callback_url", `{"callback_url": "http://www.example.com"}`)
+		if got, want := rr.Code, http.StatusOK; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+		if got, want := request("POST", "/verify-email/resend", "").Code, http.StatusBadRequest; got != want {
+			t.Errorf("handler returned wrong status code: got %v, want %v", got, want)
+		}
+	})
+}
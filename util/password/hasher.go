@@ -0,0 +1,224 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHash is returned for hashes no hasher can verify
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Hasher hashes passwords into hashes that encode the hasher's parameters, so hashes made
+// with other parameters can still be verified
+type Hasher interface {
+	// Hash returns the encoded hash of the password
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the hash
+	Verify(password, hash string) (bool, error)
+	// Handles reports whether the hash was made by this kind of hasher
+	Handles(hash string) bool
+	// Outdated reports whether the hash was made with other parameters than the hasher's
+	Outdated(hash string) bool
+}
+
+// BcryptHasher hashes passwords with bcrypt, e.g. $2a$12$...
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash returns bcrypt hash of the password
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(bytes), err
+}
+
+// Verify reports whether the password matches the bcrypt hash
+func (h *BcryptHasher) Verify(password, hash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Handles reports whether the hash is a bcrypt hash
+func (h *BcryptHasher) Handles(hash string) bool {
+	return strings.HasPrefix(hash, "$2")
+}
+
+// Outdated reports whether the bcrypt hash has another cost
+func (h *BcryptHasher) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher hashes passwords with argon2id, hashes are encoded in the PHC string
+// format, e.g. $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+type Argon2idHasher struct {
+	// Time is the number of passes over the memory
+	Time uint32
+	// Memory is in KiB
+	Memory     uint32
+	Threads    uint8
+	KeyLength  uint32
+	SaltLength uint32
+}
+
+// argon2idParams are the parameters encoded in an argon2id hash
+type argon2idParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// Hash returns argon2id hash of the password with a random salt
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify reports whether the password matches the argon2id hash, with the hash's parameters
+func (h *Argon2idHasher) Verify(password, hash string) (bool, error) {
+	params, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+// Handles reports whether the hash is an argon2id hash
+func (h *Argon2idHasher) Handles(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+// Outdated reports whether the argon2id hash has other parameters
+func (h *Argon2idHasher) Outdated(hash string) bool {
+	params, err := decodeArgon2id(hash)
+	return err != nil ||
+		params.memory != h.Memory ||
+		params.time != h.Time ||
+		params.threads != h.Threads ||
+		uint32(len(params.key)) != h.KeyLength ||
+		uint32(len(params.salt)) != h.SaltLength
+}
+
+// decodeArgon2id returns the parameters of the argon2id hash
+func decodeArgon2id(hash string) (*argon2idParams, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	params := &argon2idParams{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return nil, ErrUnknownHash
+	}
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	return params, nil
+}
+
+// Hashing hashes new passwords with Current and verifies hashes of Current and of Legacy
+// hashers, hashes of Legacy or of outdated parameters need a rehash with Current
+type Hashing struct {
+	Current Hasher
+	Legacy  []Hasher
+}
+
+// NewHashing returns hashing with argon2id that still verifies bcrypt hashes
+func NewHashing() *Hashing {
+	return &Hashing{
+		Current: &Argon2idHasher{
+			Time:       3,
+			Memory:     64 * 1024,
+			Threads:    4,
+			KeyLength:  32,
+			SaltLength: 16,
+		},
+		Legacy: []Hasher{&BcryptHasher{Cost: 12}},
+	}
+}
+
+// NewHashingFromEnv returns hashing configured by environment variables
+func NewHashingFromEnv() *Hashing {
+	argon2id := &Argon2idHasher{
+		Time:       uint32(uintFromEnv("ARGON2_TIME", 3)),
+		Memory:     uint32(uintFromEnv("ARGON2_MEMORY", 64*1024)),
+		Threads:    uint8(uintFromEnv("ARGON2_THREADS", 4)),
+		KeyLength:  32,
+		SaltLength: 16,
+	}
+	bcryptHasher := &BcryptHasher{Cost: int(uintFromEnv("BCRYPT_COST", 12))}
+
+	if os.Getenv("PASSWORD_HASHER") == "bcrypt" {
+		return &Hashing{Current: bcryptHasher, Legacy: []Hasher{argon2id}}
+	}
+	return &Hashing{Current: argon2id, Legacy: []Hasher{bcryptHasher}}
+}
+
+// uintFromEnv returns the number in the environment variable or fallback when it isn't set
+// or isn't a number
+func uintFromEnv(name string, fallback uint64) uint64 {
+	if n, err := strconv.ParseUint(os.Getenv(name), 10, 32); err == nil {
+		return n
+	}
+	return fallback
+}
+
+// Hash returns hash of the password with the current hasher
+func (h *Hashing) Hash(password string) (string, error) {
+	return h.Current.Hash(password)
+}
+
+// Verify reports whether the password matches the hash and whether the hash should be
+// replaced by a hash of the current hasher
+func (h *Hashing) Verify(password, hash string) (bool, bool, error) {
+	hashers := append([]Hasher{h.Current}, h.Legacy...)
+	for i, hasher := range hashers {
+		if !hasher.Handles(hash) {
+			continue
+		}
+		ok, err := hasher.Verify(password, hash)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, i > 0 || hasher.Outdated(hash), nil
+	}
+	return false, false, ErrUnknownHash
+}
@@ -0,0 +1,61 @@
+package password_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/ngavinsir/notification-service/util/password"
+)
+
+func TestHashing_Verify(t *testing.T) {
+	hashing := NewHashing()
+	bcryptHasher := &BcryptHasher{Cost: 4}
+	hashing.Legacy = []Hasher{bcryptHasher}
+
+	current, err := hashing.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(current, "$argon2id$v=19$m=65536,t=3,p=4$") {
+		t.Fatalf("Want argon2id hash, got %s", current)
+	}
+	legacy, err := bcryptHasher.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weaker, err := (&Argon2idHasher{Time: 1, Memory: 8 * 1024, Threads: 1, KeyLength: 32, SaltLength: 16}).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+		ok       bool
+		rehash   bool
+	}{
+		{"Current", "password", current, true, false},
+		{"Current wrong password", "wrong", current, false, false},
+		{"Legacy", "password", legacy, true, true},
+		{"Legacy wrong password", "wrong", legacy, false, false},
+		{"Outdated parameters", "password", weaker, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ok, rehash, err := hashing.Verify(test.password, test.hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != test.ok || rehash != test.rehash {
+				t.Errorf("Want ok %v and rehash %v, got %v and %v", test.ok, test.rehash, ok, rehash)
+			}
+		})
+	}
+
+	t.Run("Unknown hash", func(t *testing.T) {
+		if _, _, err := hashing.Verify("password", "plain"); err != ErrUnknownHash {
+			t.Errorf("Want %v, got %v", ErrUnknownHash, err)
+		}
+	})
+}
@@ -1,15 +1,39 @@
 package password
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
 
-// HashPassword hashes given password with bcrypt
+// Default is the hashing of HashPassword and CheckPasswordHash
+var Default = NewHashing()
+
+// HashPassword hashes given password with the current hasher of Default
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 12)
-	return string(bytes), err
+	return Default.Hash(password)
 }
 
-// CheckPasswordHash checks if the given password and hash is a match
+// CheckPasswordHash checks if the given password and hash is a match, the hash can be of
+// any hasher of Default
 func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	ok, _, err := Default.Verify(password, hash)
+	return ok && err == nil
+}
+
+// GenerateToken returns a random url safe token, e.g. for password reset links
+func GenerateToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// HashToken returns hash of the token to store instead of the token itself, tokens are
+// random so unlike passwords they don't need a slow hash
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
 }
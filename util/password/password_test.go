@@ -25,3 +25,21 @@ func TestHashPassword_IsMatch(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateToken(t *testing.T) {
+	token, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token == other {
+		t.Errorf("Want different tokens, got %s twice", token)
+	}
+	if HashToken(token) != HashToken(token) || HashToken(token) == HashToken(other) {
+		t.Errorf("Want hash matching only its token")
+	}
+}
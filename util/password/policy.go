@@ -0,0 +1,109 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxBytes is the longest password bcrypt hashes, the bytes after it would be ignored
+const MaxBytes = 72
+
+// Policy holds the rules new passwords have to follow
+type Policy struct {
+	// MinLength is the least number of characters
+	MinLength int
+	// Breached rejects passwords found in known breaches, nil skips the check
+	Breached *BreachedList
+}
+
+// NewPolicy returns new password policy
+func NewPolicy() *Policy {
+	return &Policy{
+		MinLength: 8,
+	}
+}
+
+// NewPolicyFromEnv returns new password policy configured by environment variables
+func NewPolicyFromEnv() *Policy {
+	policy := NewPolicy()
+	if minLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil {
+		policy.MinLength = minLength
+	}
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		policy.Breached = &BreachedList{Dir: dir}
+	}
+	return policy
+}
+
+// Check returns why the password of the account with the email breaks the policy, it returns
+// no reasons when the password follows every rule
+func (p *Policy) Check(password, email string) ([]string, error) {
+	var reasons []string
+	if utf8.RuneCountInString(password) < p.MinLength {
+		reasons = append(reasons, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if len(password) > MaxBytes {
+		reasons = append(reasons, fmt.Sprintf("must be at most %d bytes long", MaxBytes))
+	}
+	localPart := strings.SplitN(email, "@", 2)[0]
+	if email != "" && (strings.EqualFold(password, email) || strings.EqualFold(password, localPart)) {
+		reasons = append(reasons, "must not be the email")
+	}
+	if len(reasons) > 0 || p.Breached == nil {
+		return reasons, nil
+	}
+
+	breached, err := p.Breached.Contains(password)
+	if err != nil {
+		return nil, err
+	}
+	if breached {
+		reasons = append(reasons, "appeared in a data breach, choose another password")
+	}
+	return reasons, nil
+}
+
+// BreachedList looks passwords up in a local copy of the Pwned Passwords range files, the
+// SHA-1 hashes are split by their first 5 hex characters so only the file of the prefix is
+// read, e.g. Dir/5BAA6.txt holds lines of SUFFIX:COUNT
+type BreachedList struct {
+	Dir string
+}
+
+// Contains reports whether the password is in the list, prefixes without a file have no
+// breached passwords
+func (l *BreachedList) Contains(password string) (bool, error) {
+	hash := sha1.Sum([]byte(password))
+	hexHash := strings.ToUpper(hex.EncodeToString(hash[:]))
+	prefix, suffix := hexHash[:5], hexHash[5:]
+
+	file, err := os.Open(filepath.Join(l.Dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if !strings.EqualFold(parts[0], suffix) {
+			continue
+		}
+		// Padding lines of the range files have a count of 0
+		if len(parts) == 2 && strings.TrimSpace(parts[1]) == "0" {
+			return false, nil
+		}
+		return true, nil
+	}
+	return false, scanner.Err()
+}
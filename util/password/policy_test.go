@@ -0,0 +1,52 @@
+package password_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/ngavinsir/notification-service/util/password"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	dir, err := ioutil.TempDir("", "breached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// SHA-1 of "password1" is E38AD214943DAAD1D64C102FAEC29DE4AFE9DA3D
+	rangeFile := "1D2DA4053E34E76F6576ED1DA63134B5E2A:0\r\n214943DAAD1D64C102FAEC29DE4AFE9DA3D:2413945\r\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "E38AD.txt"), []byte(rangeFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := NewPolicy()
+	policy.Breached = &BreachedList{Dir: dir}
+
+	tests := []struct {
+		name     string
+		password string
+		reasons  int
+	}{
+		{"Valid", "correct horse battery", 0},
+		{"Too short", "short", 1},
+		{"Too many bytes", strings.Repeat("é", 40), 1},
+		{"Email", "Example@Example.com", 1},
+		{"Local part of email", "example", 2},
+		{"Breached", "password1", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reasons, err := policy.Check(test.password, "example@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(reasons), test.reasons; got != want {
+				t.Errorf("Want %d reasons, got %v", want, reasons)
+			}
+		})
+	}
+}
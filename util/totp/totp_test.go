@@ -0,0 +1,57 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/ngavinsir/notification-service/util/totp"
+)
+
+// rfcSecret is the SHA1 secret of the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, test := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(test.time, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code {
+			t.Errorf("Want code %s at %d, got %s", test.code, test.time, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, err := Code(secret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if step, ok := Validate(secret, code, now.Add(Period)); !ok || step != Step(now) {
+		t.Errorf("Want code of the previous step accepted")
+	}
+	if _, ok := Validate(secret, code, now.Add(3*Period)); ok {
+		t.Errorf("Want expired code rejected")
+	}
+	if !strings.HasPrefix(URI("Notification Service", "example@example.com", secret), "otpauth://totp/Notification%20Service:example@example.com?") {
+		t.Errorf("Want otpauth uri, got %s", URI("Notification Service", "example@example.com", secret))
+	}
+}